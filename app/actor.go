@@ -0,0 +1,46 @@
+package app
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+type actorKey struct{}
+
+// Actor records the user making each request, read from the given header.
+// The service does not authenticate users itself: the header is meant to be
+// set by an authenticating proxy in front of it, which must drop the value
+// a client sends. With no header configured no user is recorded.
+func Actor(header string, next http.Handler) http.Handler {
+	if header == "" {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if user := strings.TrimSpace(r.Header.Get(header)); user != "" {
+			r = r.WithContext(context.WithValue(r.Context(), actorKey{}, user))
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// actorFrom returns the user making the request, empty when unknown.
+func actorFrom(ctx context.Context) string {
+	user, _ := ctx.Value(actorKey{}).(string)
+	return user
+}
+
+// stamped returns the time and user to record on a write. Times are kept to
+// the millisecond, as Mongo stores them.
+func stamped(ctx context.Context) (time.Time, string) {
+	return time.Now().UTC().Truncate(time.Millisecond), actorFrom(ctx)
+}
+
+// modified adds the updatedAt and updatedBy stamps to the fields of an update.
+func modified(ctx context.Context, fields bson.M) bson.M {
+	fields["updatedAt"], fields["updatedBy"] = stamped(ctx)
+	return fields
+}
@@ -0,0 +1,113 @@
+package app
+
+import (
+	"context"
+	"errors"
+
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// App is the application container built once in main. It owns the
+// configuration, the single Mongo client and the stores built on top of it.
+type App struct {
+	cfg    Config
+	client *mongo.Client
+	stores Stores
+	stop   context.CancelFunc // ends the background refresh, if one runs
+}
+
+// New returns an App for the given configuration. Nothing is opened until Connect.
+func New(cfg Config) *App {
+	return &App{cfg: cfg}
+}
+
+// Config returns the configuration the App was built with.
+func (a *App) Config() Config {
+	return a.cfg
+}
+
+// Connect opens the configured store. For Mongo it dials one client, retrying
+// with backoff until the server answers or the retry budget is spent.
+func (a *App) Connect(ctx context.Context) error {
+	switch a.cfg.Store {
+	case "memory":
+		a.stores = NewMemoryStores() // no network needed, data is lost on exit
+	case "mongo":
+		client, err := connectMongo(ctx, a.cfg.Mongo)
+		if err != nil {
+			return err
+		}
+		a.client = client
+		db := client.Database(a.cfg.Mongo.Database)
+		if err := migrateSubCategories(ctx, db, a.cfg.Mongo.Collections); err != nil {
+			return err
+		}
+		if a.cfg.Mongo.DropMigrationBackup {
+			if err := dropMigrationBackup(ctx, db, a.cfg.Mongo.Collections); err != nil {
+				return err
+			}
+		}
+		if err := migrateBrandAssignments(ctx, db, a.cfg.Mongo.Collections); err != nil {
+			return err
+		}
+		if err := migrateVersions(ctx, db, a.cfg.Mongo.Collections); err != nil {
+			return err
+		}
+		if err := migrateTimestamps(ctx, db, a.cfg.Mongo.Collections); err != nil {
+			return err
+		}
+		if err := ensureUniqueIndexes(ctx, db, a.cfg.Mongo.Collections); err != nil {
+			return err
+		}
+		transactions, err := supportsTransactions(ctx, db)
+		if err != nil {
+			return err
+		}
+		if !transactions {
+			if !a.cfg.Mongo.AllowNonTransactional {
+				return errors.New("MongoDB is a standalone server without transactions; run it as a replica set, or set mongo.allowNonTransactional to accept writes spanning several documents that are not atomic")
+			}
+			logWarnf("MongoDB is a standalone server: writes spanning several documents are not atomic")
+		}
+		a.stores = NewMongoStores(db, a.cfg.Mongo.Collections, transactions)
+		if err := (mongoSearch{db.Collection(a.cfg.Mongo.Collections.Search)}).ensureIndexes(ctx); err != nil {
+			return err
+		}
+		if err := ensureAuditIndexes(ctx, db.Collection(a.cfg.Mongo.Collections.Audit)); err != nil {
+			return err
+		}
+		if err := rebuildSearch(ctx, a.stores); err != nil {
+			return err
+		}
+		if err := a.stores.suggestions.reload(ctx, a.stores); err != nil {
+			return err
+		}
+		if interval := a.cfg.Suggest.RefreshInterval; interval > 0 {
+			refreshCtx, cancel := context.WithCancel(context.Background())
+			a.stop = cancel
+			go refreshSuggestions(refreshCtx, a.stores, interval)
+		}
+	default:
+		return errors.New("unknown store " + a.cfg.Store)
+	}
+	return nil
+}
+
+// Close stops the suggestion refresh and releases the Mongo client, if one was opened.
+func (a *App) Close(ctx context.Context) error {
+	if a.stop != nil {
+		a.stop()
+		a.stop = nil
+	}
+	if a.client == nil {
+		return nil
+	}
+	err := a.client.Disconnect(ctx)
+	a.client = nil
+	return err
+}
+
+// Stores returns the stores opened by Connect.
+func (a *App) Stores() Stores {
+	return a.stores
+}
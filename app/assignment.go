@@ -0,0 +1,201 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// brandAssignment says a brand is sold in a subcategory. A brand can be
+// assigned to any number of subcategories.
+type brandAssignment struct {
+	Key  string `json:"key"` // bid:scid
+	BId  string `json:"bid"`
+	ScId string `json:"scid"`
+}
+
+func assignmentKey(bid, scid string) string {
+	return bid + ":" + scid
+}
+
+// assignedBrands lists brands for GetAllBrand, resolving the scid and cid
+// filters through the assignments: scid keeps the brands sold in that
+// subcategory, cid those sold anywhere below that category.
+type assignedBrands struct{ s Stores }
+
+func (l assignedBrands) resolve(ctx context.Context, filter bson.M) (bson.M, error) {
+	scid, byScid := filter["scid"].(string)
+	cid, byCid := filter["cid"].(string)
+	if !byScid && !byCid {
+		return filter, nil
+	}
+	f := bson.M{}
+	for k, v := range filter {
+		if k != "scid" && k != "cid" {
+			f[k] = v
+		}
+	}
+	var scids []string
+	if byCid {
+		below, err := l.s.Categories.Find(ctx, findQuery{Filter: bson.M{"cpath": cid}})
+		if err != nil {
+			return nil, err
+		}
+		for _, c := range append(below, category{CId: cid}) {
+			if !byScid || c.CId == scid {
+				scids = append(scids, c.CId)
+			}
+		}
+	} else {
+		scids = []string{scid}
+	}
+	assignments, err := l.s.BrandAssignments.Find(ctx, findQuery{Filter: bson.M{"scid": bson.M{"$in": append([]string{}, scids...)}}})
+	if err != nil {
+		return nil, err
+	}
+	bids := []string{}
+	for _, a := range assignments {
+		bids = append(bids, a.BId)
+	}
+	f["bid"] = bson.M{"$in": bids}
+	return f, nil
+}
+
+func (l assignedBrands) Find(ctx context.Context, q findQuery) ([]brand, error) {
+	filter, err := l.resolve(ctx, q.Filter)
+	if err != nil {
+		return nil, err
+	}
+	q.Filter = filter
+	return l.s.Brands.Find(ctx, q)
+}
+
+func (l assignedBrands) Count(ctx context.Context, filter bson.M) (int64, error) {
+	filter, err := l.resolve(ctx, filter)
+	if err != nil {
+		return 0, err
+	}
+	return l.s.Brands.Count(ctx, filter)
+}
+
+// Attach Brand to a SubCategory
+
+func (h *Handler) AttachBrand(w http.ResponseWriter, r *http.Request) {
+	type attachBody struct {
+		BId  string `json:"bid" validate:"required,alphanum,min=4,max=26"`
+		ScId string `json:"scid" validate:"required,alphanum,min=4,max=26"`
+	}
+	var body attachBody
+	if !decodeBody(w, r, &body) || !validateBody(w, r, body) { // attach struct validation
+		return
+	}
+	a := brandAssignment{assignmentKey(body.BId, body.ScId), body.BId, body.ScId}
+	err := h.store.Tx.Run(r.Context(), func(ctx context.Context) error {
+		var details []fieldError
+		bexists, err := h.store.Brands.Exists(ctx, body.BId)
+		if err != nil {
+			return err
+		}
+		if !bexists {
+			details = append(details, fieldError{Field: "bid", Message: "no such document"})
+		}
+		sexists, err := h.store.SubCategories.Exists(ctx, body.ScId)
+		if err != nil {
+			return err
+		}
+		if !sexists {
+			details = append(details, fieldError{Field: "scid", Message: "no such document"})
+		}
+		if len(details) > 0 {
+			return requestError{http.StatusUnprocessableEntity, codeInvalidReference, "Invalid reference", details}
+		}
+		exists, err := h.store.BrandAssignments.Exists(ctx, a.Key)
+		if err != nil {
+			return err
+		}
+		if exists {
+			return requestError{http.StatusConflict, codeDuplicate, "Brand is already assigned to that subcategory", nil}
+		}
+		_, err = h.store.BrandAssignments.Insert(ctx, a)
+		return err
+	})
+	if err != nil {
+		storeError(w, r, err)
+		return
+	}
+	logInfof("assigned brand %s to subcategory %s", a.BId, a.ScId)
+	writeJSON(w, http.StatusCreated, a)
+}
+
+// Detach Brand from a SubCategory
+
+func (h *Handler) DetachBrand(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r) //get Parameter values as string
+	bid, scid := vars["bid"], vars["scid"]
+	if !validID(w, r, bid, "Brand") || !validID(w, r, scid, "SubCategory") {
+		return
+	}
+	key := assignmentKey(bid, scid)
+	var deleted int64
+	err := h.store.Tx.Run(r.Context(), func(ctx context.Context) error {
+		exists, err := h.store.BrandAssignments.Exists(ctx, key)
+		if err != nil {
+			return err
+		}
+		if !exists {
+			return ErrNotFound
+		}
+		// products of the brand in that subcategory would no longer be valid
+		products, err := h.store.Products.Find(ctx, findQuery{Filter: bson.M{"subprod.brandid": bid, "subprod.subcategoryid": scid}})
+		if err != nil {
+			return err
+		}
+		if len(products) > 0 {
+			details := make([]fieldError, 0, len(products))
+			for _, p := range products {
+				details = append(details, fieldError{Field: "product", Message: fmt.Sprintf("product %s is sold as brand %s in subcategory %s", p.PId, bid, scid)})
+			}
+			return requestError{http.StatusConflict, codeHasDependents, "Document is still referenced", details}
+		}
+		deleted, err = h.store.BrandAssignments.Delete(ctx, key, anyVersion)
+		return err
+	})
+	if err != nil {
+		storeError(w, r, err)
+		return
+	}
+	logInfof("detached brand %s from subcategory %s", bid, scid)
+	writeJSON(w, http.StatusOK, deleted) // return number of documents deleted
+}
+
+// Get the SubCategories a Brand is sold in
+
+func (h *Handler) GetBrandSubCategories(w http.ResponseWriter, r *http.Request) {
+	params := mux.Vars(r)["id"] //get Parameter value as string
+	if !validID(w, r, params, "Brand") {
+		return
+	}
+	ctx := r.Context()
+	if _, err := h.store.Brands.Get(ctx, params); err != nil {
+		storeError(w, r, err)
+		return
+	}
+	assignments, err := h.store.BrandAssignments.Find(ctx, findQuery{Filter: bson.M{"bid": params}})
+	if err != nil {
+		storeError(w, r, err)
+		return
+	}
+	scids := []string{}
+	for _, a := range assignments {
+		scids = append(scids, a.ScId)
+	}
+	subs, err := h.store.SubCategories.Find(ctx, findQuery{Filter: bson.M{"scid": bson.M{"$in": scids}}, Sort: "scname"})
+	if err != nil {
+		storeError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string][]subcategory{"data": subs})
+}
@@ -0,0 +1,447 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sort"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// actions recorded in the audit log
+const (
+	actionCreate = "create"
+	actionUpdate = "update"
+	actionStatus = "status"
+	actionMove   = "move"
+	actionDelete = "delete"
+	actionRevert = "revert"
+)
+
+// auditEntry is one write to a catalog document. Before and After are the
+// whole document on either side of it, left out when it did not exist;
+// subcategories are stored as categories with a parent, so theirs show the
+// category fields. Entries are only ever appended.
+type auditEntry struct {
+	Key        string        `json:"key"` // sorts in the order the entries were made
+	Entity     string        `json:"entity"`
+	ID         string        `json:"id"`
+	Revision   int64         `json:"revision"` // version after the write; one more than the last for a delete
+	Action     string        `json:"action"`
+	RevertedTo int64         `json:"revertedTo,omitempty" bson:"revertedTo,omitempty"` // the revision a revert went back to
+	Changes    []fieldChange `json:"changes"`
+	Before     bson.M        `json:"before,omitempty" bson:"before,omitempty"`
+	After      bson.M        `json:"after,omitempty" bson:"after,omitempty"`
+	Actor      string        `json:"actor,omitempty" bson:"actor,omitempty"`
+	At         time.Time     `json:"at"`
+	RequestID  string        `json:"requestId,omitempty" bson:"requestId,omitempty"`
+}
+
+type revertKey struct{}
+
+// reverting marks the writes made with the returned context as going back to revision.
+func reverting(ctx context.Context, revision int64) context.Context {
+	return context.WithValue(ctx, revertKey{}, revision)
+}
+
+// fieldChange is one field a write changed; nested fields are named with
+// dots, as in "subprod.brandid".
+type fieldChange struct {
+	Field string      `json:"field"`
+	From  interface{} `json:"from"`
+	To    interface{} `json:"to"`
+}
+
+// stampFields are kept in the snapshots but left out of the changes, as
+// every entry records the revision, time and user on its own.
+var stampFields = map[string]bool{"version": true, "createdAt": true, "updatedAt": true, "createdBy": true, "updatedBy": true}
+
+// diff lists the fields that differ between two snapshots, by name.
+func diff(before, after bson.M) []fieldChange {
+	from, to := bson.M{}, bson.M{}
+	flatten(before, "", from)
+	flatten(after, "", to)
+	fields := []string{}
+	for f := range from {
+		fields = append(fields, f)
+	}
+	for f := range to {
+		if _, ok := from[f]; !ok {
+			fields = append(fields, f)
+		}
+	}
+	sort.Strings(fields)
+	changes := []fieldChange{}
+	for _, f := range fields {
+		if !stampFields[f] && !reflect.DeepEqual(from[f], to[f]) {
+			changes = append(changes, fieldChange{f, from[f], to[f]})
+		}
+	}
+	return changes
+}
+
+// flatten copies the fields of doc into out, naming nested ones with dots.
+func flatten(doc bson.M, prefix string, out bson.M) {
+	for k, v := range doc {
+		if nested, ok := v.(bson.M); ok {
+			flatten(nested, prefix+k+".", out)
+			continue
+		}
+		out[prefix+k] = v
+	}
+}
+
+// auditKeys issues entry keys: ULIDs whose time part goes up by at least a
+// millisecond each time, so entries made within one millisecond keep their order.
+type auditKeys struct {
+	mu   sync.Mutex
+	last int64
+}
+
+func (k *auditKeys) next(at time.Time) (string, error) {
+	k.mu.Lock()
+	ms := at.UnixMilli()
+	if ms <= k.last {
+		ms = k.last + 1
+	}
+	k.last = ms
+	k.mu.Unlock()
+	return newULID(time.UnixMilli(ms))
+}
+
+// auditor appends an entry to the audit log for every write to a catalog
+// document, in the unit of work of the write, so the log and the catalog
+// change together.
+type auditor struct {
+	tx   UnitOfWork
+	log  AuditStore
+	keys *auditKeys
+}
+
+// getter reads a document for its snapshot.
+type getter func(ctx context.Context, id string) (interface{}, error)
+
+// change runs write on the document id names and records it, with the
+// document as get reads it before and after. A write that left the document
+// as it was, such as deleting one that does not exist, is not recorded.
+// entity is empty for category documents, which are a category or a
+// subcategory depending on their parent.
+func (a auditor) change(ctx context.Context, entity, id, action string, get getter, write func(ctx context.Context) error) error {
+	return a.tx.Run(ctx, func(ctx context.Context) error {
+		before, err := snapshot(ctx, get, id)
+		if err != nil {
+			return err
+		}
+		if err := write(ctx); err != nil {
+			return err
+		}
+		after, err := snapshot(ctx, get, id)
+		if err != nil {
+			return err
+		}
+		current := after
+		if current == nil {
+			current = before
+		}
+		if current == nil || before != nil && after != nil && before["version"] == after["version"] {
+			return nil
+		}
+		if entity == "" {
+			entity = "category"
+			if parent, _ := current["cparent"].(string); parent != "" {
+				entity = "subcategory"
+			}
+		}
+		revision, _ := current["version"].(int64)
+		if after == nil {
+			revision++
+		}
+		at, actor := stamped(ctx)
+		key, err := a.keys.next(at)
+		if err != nil {
+			return err
+		}
+		revertedTo, _ := ctx.Value(revertKey{}).(int64)
+		return a.log.Insert(ctx, auditEntry{key, entity, id, revision, action, revertedTo, diff(before, after), before, after, actor, at, requestIDOf(ctx)})
+	})
+}
+
+// snapshot returns the document as a BSON document, nil when there is none.
+func snapshot(ctx context.Context, get getter, id string) (bson.M, error) {
+	v, err := get(ctx, id)
+	if errors.Is(err, ErrNotFound) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	return toDoc(v)
+}
+
+// withAudit records the writes of every catalog store in the audit log.
+// Category and subcategory snapshots are both read from the category
+// collection, as the subcategory view leaves out the path.
+func withAudit(s Stores) Stores {
+	a := auditor{s.Tx, s.Audit, &auditKeys{}}
+	categories := s.Categories
+	node := func(ctx context.Context, id string) (interface{}, error) { return categories.Get(ctx, id) }
+	s.Products = auditedProductStore{s.Products, a}
+	s.Categories = auditedCategoryStore{s.Categories, a, node}
+	s.SubCategories = auditedSubCategoryStore{s.SubCategories, a, node}
+	s.Brands = auditedBrandStore{s.Brands, a}
+	s.Varients = auditedVarientStore{s.Varients, a}
+	return s
+}
+
+type auditedProductStore struct {
+	ProductStore
+	a auditor
+}
+
+func (s auditedProductStore) get(ctx context.Context, pid string) (interface{}, error) {
+	return s.ProductStore.Get(ctx, pid)
+}
+
+func (s auditedProductStore) Insert(ctx context.Context, prod product) (id interface{}, err error) {
+	err = s.a.change(ctx, "product", prod.PId, actionCreate, s.get, func(ctx context.Context) error {
+		id, err = s.ProductStore.Insert(ctx, prod)
+		return err
+	})
+	return id, err
+}
+
+func (s auditedProductStore) Update(ctx context.Context, body productUpdate, version int64) (p product, err error) {
+	err = s.a.change(ctx, "product", body.PId, actionUpdate, s.get, func(ctx context.Context) error {
+		p, err = s.ProductStore.Update(ctx, body, version)
+		return err
+	})
+	return p, err
+}
+
+func (s auditedProductStore) UpdateStatus(ctx context.Context, pid string, status bool, version int64) (p product, err error) {
+	err = s.a.change(ctx, "product", pid, actionStatus, s.get, func(ctx context.Context) error {
+		p, err = s.ProductStore.UpdateStatus(ctx, pid, status, version)
+		return err
+	})
+	return p, err
+}
+
+func (s auditedProductStore) Restore(ctx context.Context, prod product, version int64) (p product, err error) {
+	err = s.a.change(ctx, "product", prod.PId, actionRevert, s.get, func(ctx context.Context) error {
+		p, err = s.ProductStore.Restore(ctx, prod, version)
+		return err
+	})
+	return p, err
+}
+
+func (s auditedProductStore) Delete(ctx context.Context, pid string, version int64) (n int64, err error) {
+	err = s.a.change(ctx, "product", pid, actionDelete, s.get, func(ctx context.Context) error {
+		n, err = s.ProductStore.Delete(ctx, pid, version)
+		return err
+	})
+	return n, err
+}
+
+type auditedCategoryStore struct {
+	CategoryStore
+	a   auditor
+	get getter
+}
+
+func (s auditedCategoryStore) Insert(ctx context.Context, cat category) (id interface{}, err error) {
+	err = s.a.change(ctx, "", cat.CId, actionCreate, s.get, func(ctx context.Context) error {
+		id, err = s.CategoryStore.Insert(ctx, cat)
+		return err
+	})
+	return id, err
+}
+
+func (s auditedCategoryStore) Update(ctx context.Context, body categoryUpdate, version int64) (c category, err error) {
+	err = s.a.change(ctx, "", body.CId, actionUpdate, s.get, func(ctx context.Context) error {
+		c, err = s.CategoryStore.Update(ctx, body, version)
+		return err
+	})
+	return c, err
+}
+
+func (s auditedCategoryStore) UpdateStatus(ctx context.Context, cid string, status bool, version int64) (c category, err error) {
+	err = s.a.change(ctx, "", cid, actionStatus, s.get, func(ctx context.Context) error {
+		c, err = s.CategoryStore.UpdateStatus(ctx, cid, status, version)
+		return err
+	})
+	return c, err
+}
+
+func (s auditedCategoryStore) Move(ctx context.Context, cid, parent string, path []string) (c category, err error) {
+	err = s.a.change(ctx, "", cid, actionMove, s.get, func(ctx context.Context) error {
+		c, err = s.CategoryStore.Move(ctx, cid, parent, path)
+		return err
+	})
+	return c, err
+}
+
+func (s auditedCategoryStore) Restore(ctx context.Context, cat category, version int64) (c category, err error) {
+	err = s.a.change(ctx, "", cat.CId, actionRevert, s.get, func(ctx context.Context) error {
+		c, err = s.CategoryStore.Restore(ctx, cat, version)
+		return err
+	})
+	return c, err
+}
+
+func (s auditedCategoryStore) Delete(ctx context.Context, cid string, version int64) (n int64, err error) {
+	err = s.a.change(ctx, "", cid, actionDelete, s.get, func(ctx context.Context) error {
+		n, err = s.CategoryStore.Delete(ctx, cid, version)
+		return err
+	})
+	return n, err
+}
+
+type auditedSubCategoryStore struct {
+	SubCategoryStore
+	a   auditor
+	get getter
+}
+
+func (s auditedSubCategoryStore) Insert(ctx context.Context, subc subcategory) (id interface{}, err error) {
+	err = s.a.change(ctx, "", subc.ScId, actionCreate, s.get, func(ctx context.Context) error {
+		id, err = s.SubCategoryStore.Insert(ctx, subc)
+		return err
+	})
+	return id, err
+}
+
+func (s auditedSubCategoryStore) Update(ctx context.Context, body subcategoryUpdate, version int64) (sc subcategory, err error) {
+	err = s.a.change(ctx, "", body.ScId, actionUpdate, s.get, func(ctx context.Context) error {
+		sc, err = s.SubCategoryStore.Update(ctx, body, version)
+		return err
+	})
+	return sc, err
+}
+
+func (s auditedSubCategoryStore) UpdateStatus(ctx context.Context, scid string, status bool, version int64) (sc subcategory, err error) {
+	err = s.a.change(ctx, "", scid, actionStatus, s.get, func(ctx context.Context) error {
+		sc, err = s.SubCategoryStore.UpdateStatus(ctx, scid, status, version)
+		return err
+	})
+	return sc, err
+}
+
+func (s auditedSubCategoryStore) Delete(ctx context.Context, scid string, version int64) (n int64, err error) {
+	err = s.a.change(ctx, "", scid, actionDelete, s.get, func(ctx context.Context) error {
+		n, err = s.SubCategoryStore.Delete(ctx, scid, version)
+		return err
+	})
+	return n, err
+}
+
+type auditedBrandStore struct {
+	BrandStore
+	a auditor
+}
+
+func (s auditedBrandStore) get(ctx context.Context, bid string) (interface{}, error) {
+	return s.BrandStore.Get(ctx, bid)
+}
+
+func (s auditedBrandStore) Insert(ctx context.Context, b brand) (id interface{}, err error) {
+	err = s.a.change(ctx, "brand", b.BId, actionCreate, s.get, func(ctx context.Context) error {
+		id, err = s.BrandStore.Insert(ctx, b)
+		return err
+	})
+	return id, err
+}
+
+func (s auditedBrandStore) Update(ctx context.Context, body brandUpdate, version int64) (b brand, err error) {
+	err = s.a.change(ctx, "brand", body.BId, actionUpdate, s.get, func(ctx context.Context) error {
+		b, err = s.BrandStore.Update(ctx, body, version)
+		return err
+	})
+	return b, err
+}
+
+func (s auditedBrandStore) UpdateStatus(ctx context.Context, bid string, status bool, version int64) (b brand, err error) {
+	err = s.a.change(ctx, "brand", bid, actionStatus, s.get, func(ctx context.Context) error {
+		b, err = s.BrandStore.UpdateStatus(ctx, bid, status, version)
+		return err
+	})
+	return b, err
+}
+
+func (s auditedBrandStore) Restore(ctx context.Context, br brand, version int64) (b brand, err error) {
+	err = s.a.change(ctx, "brand", br.BId, actionRevert, s.get, func(ctx context.Context) error {
+		b, err = s.BrandStore.Restore(ctx, br, version)
+		return err
+	})
+	return b, err
+}
+
+func (s auditedBrandStore) Delete(ctx context.Context, bid string, version int64) (n int64, err error) {
+	err = s.a.change(ctx, "brand", bid, actionDelete, s.get, func(ctx context.Context) error {
+		n, err = s.BrandStore.Delete(ctx, bid, version)
+		return err
+	})
+	return n, err
+}
+
+type auditedVarientStore struct {
+	VarientStore
+	a auditor
+}
+
+func (s auditedVarientStore) get(ctx context.Context, vid string) (interface{}, error) {
+	return s.VarientStore.Get(ctx, vid)
+}
+
+func (s auditedVarientStore) Insert(ctx context.Context, v varient) (id interface{}, err error) {
+	err = s.a.change(ctx, "varient", v.VId, actionCreate, s.get, func(ctx context.Context) error {
+		id, err = s.VarientStore.Insert(ctx, v)
+		return err
+	})
+	return id, err
+}
+
+func (s auditedVarientStore) Update(ctx context.Context, body varientUpdate, version int64) (v varient, err error) {
+	err = s.a.change(ctx, "varient", body.VId, actionUpdate, s.get, func(ctx context.Context) error {
+		v, err = s.VarientStore.Update(ctx, body, version)
+		return err
+	})
+	return v, err
+}
+
+func (s auditedVarientStore) UpdateStatus(ctx context.Context, vid string, status bool, version int64) (v varient, err error) {
+	err = s.a.change(ctx, "varient", vid, actionStatus, s.get, func(ctx context.Context) error {
+		v, err = s.VarientStore.UpdateStatus(ctx, vid, status, version)
+		return err
+	})
+	return v, err
+}
+
+func (s auditedVarientStore) Restore(ctx context.Context, va varient, version int64) (v varient, err error) {
+	err = s.a.change(ctx, "varient", va.VId, actionRevert, s.get, func(ctx context.Context) error {
+		v, err = s.VarientStore.Restore(ctx, va, version)
+		return err
+	})
+	return v, err
+}
+
+func (s auditedVarientStore) Delete(ctx context.Context, vid string, version int64) (n int64, err error) {
+	err = s.a.change(ctx, "varient", vid, actionDelete, s.get, func(ctx context.Context) error {
+		n, err = s.VarientStore.Delete(ctx, vid, version)
+		return err
+	})
+	return n, err
+}
+
+// ensureAuditIndexes creates the index the history of one document is read
+// through; the unique key index comes with the others.
+func ensureAuditIndexes(ctx context.Context, coll *mongo.Collection) error {
+	_, err := coll.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "id", Value: 1}, {Key: "entity", Value: 1}, {Key: "key", Value: 1}},
+		Options: options.Index().SetName("history"),
+	})
+	return err
+}
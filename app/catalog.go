@@ -0,0 +1,281 @@
+package app
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// categoryNode is a category with its subcategories, as returned by the tree endpoints.
+type categoryNode struct {
+	category
+	Products      *int64            `json:"products,omitempty"` // with products=true, counting the whole subtree
+	SubCategories []subcategoryNode `json:"subcategories"`
+}
+
+// subcategoryNode nests the categories below it as subcategories, to any depth.
+type subcategoryNode struct {
+	subcategory
+	Products      *int64            `json:"products,omitempty"`
+	SubCategories []subcategoryNode `json:"subcategories"`
+	Brands        []brandNode       `json:"brands"`
+}
+
+type brandNode struct {
+	brand
+	Products *int64 `json:"products,omitempty"`
+}
+
+// treeOptions are the query parameters shared by the tree endpoints.
+type treeOptions struct {
+	active   bool // leave out inactive nodes and products
+	products bool // count the products under every node
+}
+
+// parseTreeOptions reads active and products. On a bad value it answers 400 and returns false.
+func parseTreeOptions(w http.ResponseWriter, r *http.Request) (treeOptions, bool) {
+	var opts treeOptions
+	var problems []fieldError
+	for _, p := range []struct {
+		name string
+		dst  *bool
+	}{{"active", &opts.active}, {"products", &opts.products}} {
+		v := r.URL.Query().Get(p.name)
+		if v == "" {
+			continue
+		}
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			problems = append(problems, fieldError{Field: p.name, Message: p.name + " must be true or false"})
+		}
+		*p.dst = b
+	}
+	if len(problems) > 0 {
+		writeError(w, r, http.StatusBadRequest, codeBadRequest, "Invalid query parameters", problems...)
+		return opts, false
+	}
+	return opts, true
+}
+
+// treeBuilder loads the parts of the hierarchy below some roots and nests them.
+type treeBuilder struct {
+	store    Stores
+	opts     treeOptions
+	counts   map[string]map[string]int64 // facet name -> id -> products
+	children map[string][]subcategory    // parent id -> subcategories directly below
+	brands   map[string][]brandNode      // scid -> brands assigned to it
+}
+
+// filter adds the status condition to f when only active nodes are wanted.
+func (b *treeBuilder) filter(f bson.M, statusField string) bson.M {
+	if b.opts.active {
+		f[statusField] = true
+	}
+	return f
+}
+
+// loadCounts counts the products per subcategory, and per brand within each
+// of the given subcategories, in one query.
+func (b *treeBuilder) loadCounts(ctx context.Context, scids []string) error {
+	if !b.opts.products {
+		return nil
+	}
+	filter := b.filter(bson.M{}, "pstatus")
+	specs := []facetSpec{{Name: "subcategory", Field: "subprod.subcategoryid", Filter: filter}}
+	for _, scid := range scids {
+		f := bson.M{"subprod.subcategoryid": scid}
+		for k, v := range filter {
+			f[k] = v
+		}
+		specs = append(specs, facetSpec{Name: "brand:" + scid, Field: "subprod.brandid", Filter: f})
+	}
+	counts, err := b.store.Products.Facets(ctx, specs)
+	if err != nil {
+		return err
+	}
+	b.counts = map[string]map[string]int64{}
+	for name, values := range counts {
+		b.counts[name] = map[string]int64{}
+		for _, c := range values {
+			if id, ok := c.Value.(string); ok {
+				b.counts[name][id] = c.Count
+			}
+		}
+	}
+	return nil
+}
+
+// count returns the product count of a node, or nil when counts were not asked for.
+func (b *treeBuilder) count(facet, id string) *int64 {
+	if b.counts == nil {
+		return nil
+	}
+	n := b.counts[facet][id]
+	return &n
+}
+
+// load fetches every subcategory below the given roots, at any depth, their
+// brands and, when asked for, the product counts.
+func (b *treeBuilder) load(ctx context.Context, roots []string) error {
+	subs, err := b.store.SubCategories.Find(ctx, findQuery{Filter: b.filter(bson.M{"cpath": bson.M{"$in": roots}}, "scstatus"), Sort: "scname"})
+	if err != nil {
+		return err
+	}
+	b.children = map[string][]subcategory{}
+	scids := append([]string{}, roots...) // a subcategory root has brands too
+	for _, sc := range subs {
+		b.children[sc.CId] = append(b.children[sc.CId], sc)
+		scids = append(scids, sc.ScId)
+	}
+	if err := b.loadCounts(ctx, scids); err != nil {
+		return err
+	}
+	assignments, err := b.store.BrandAssignments.Find(ctx, findQuery{Filter: bson.M{"scid": bson.M{"$in": scids}}})
+	if err != nil {
+		return err
+	}
+	assigned := map[string][]string{} // bid -> scids
+	bids := []string{}
+	for _, a := range assignments {
+		if assigned[a.BId] == nil {
+			bids = append(bids, a.BId)
+		}
+		assigned[a.BId] = append(assigned[a.BId], a.ScId)
+	}
+	brands, err := b.store.Brands.Find(ctx, findQuery{Filter: b.filter(bson.M{"bid": bson.M{"$in": bids}}, "bstatus"), Sort: "bname"})
+	if err != nil {
+		return err
+	}
+	b.brands = map[string][]brandNode{}
+	for _, br := range brands {
+		for _, scid := range assigned[br.BId] {
+			b.brands[scid] = append(b.brands[scid], brandNode{brand: br, Products: b.count("brand:"+scid, br.BId)})
+		}
+	}
+	return nil
+}
+
+// below returns the nodes directly under parent and the products under all of
+// them. An inactive subcategory left out with active=true hides its subtree.
+func (b *treeBuilder) below(parent string) ([]subcategoryNode, *int64) {
+	nodes := make([]subcategoryNode, 0, len(b.children[parent]))
+	total := b.count("subcategory", parent)
+	for _, sc := range b.children[parent] {
+		node := b.node(sc)
+		if total != nil {
+			*total += *node.Products
+		}
+		nodes = append(nodes, node)
+	}
+	return nodes, total
+}
+
+func (b *treeBuilder) node(sc subcategory) subcategoryNode {
+	children, products := b.below(sc.ScId)
+	brands := b.brands[sc.ScId]
+	if brands == nil {
+		brands = []brandNode{}
+	}
+	return subcategoryNode{subcategory: sc, Products: products, SubCategories: children, Brands: brands}
+}
+
+// categories returns the nodes of the categories matching filter, with everything below them.
+func (b *treeBuilder) categories(ctx context.Context, filter bson.M) ([]categoryNode, error) {
+	cats, err := b.store.Categories.Find(ctx, findQuery{Filter: b.filter(filter, "cstatus"), Sort: "cname"})
+	if err != nil {
+		return nil, err
+	}
+	cids := make([]string, 0, len(cats))
+	for _, c := range cats {
+		cids = append(cids, c.CId)
+	}
+	if err := b.load(ctx, cids); err != nil {
+		return nil, err
+	}
+	nodes := make([]categoryNode, 0, len(cats))
+	for _, c := range cats {
+		children, products := b.below(c.CId)
+		nodes = append(nodes, categoryNode{category: c, Products: products, SubCategories: children})
+	}
+	return nodes, nil
+}
+
+// subcategories returns the nodes of the subcategories matching filter, with everything below them.
+func (b *treeBuilder) subcategories(ctx context.Context, filter bson.M) ([]subcategoryNode, error) {
+	subs, err := b.store.SubCategories.Find(ctx, findQuery{Filter: b.filter(filter, "scstatus"), Sort: "scname"})
+	if err != nil {
+		return nil, err
+	}
+	scids := make([]string, 0, len(subs))
+	for _, sc := range subs {
+		scids = append(scids, sc.ScId)
+	}
+	if err := b.load(ctx, scids); err != nil {
+		return nil, err
+	}
+	nodes := make([]subcategoryNode, 0, len(subs))
+	for _, sc := range subs {
+		nodes = append(nodes, b.node(sc))
+	}
+	return nodes, nil
+}
+
+// Get Catalog Tree
+
+func (h *Handler) CatalogTree(w http.ResponseWriter, r *http.Request) {
+	opts, ok := parseTreeOptions(w, r)
+	if !ok {
+		return
+	}
+	b := &treeBuilder{store: h.store, opts: opts}
+	nodes, err := b.categories(r.Context(), bson.M{"cparent": ""}) // the top-level categories
+	if err != nil {
+		storeError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string][]categoryNode{"data": nodes})
+}
+
+// Get Catalog Subtree of a category or subcategory
+
+func (h *Handler) CatalogSubtree(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r) //get Parameter values as string
+	kind, id := vars["kind"], vars["id"]
+	if !validID(w, r, id, "Category or SubCategory") {
+		return
+	}
+	opts, ok := parseTreeOptions(w, r)
+	if !ok {
+		return
+	}
+	ctx := r.Context()
+	b := &treeBuilder{store: h.store, opts: opts}
+
+	var (
+		result interface{}
+		err    error
+	)
+	switch kind { // the route only lets category and subcategory through
+	case "category":
+		var nodes []categoryNode
+		if nodes, err = b.categories(ctx, bson.M{"cid": id}); len(nodes) > 0 {
+			result = nodes[0]
+		}
+	case "subcategory":
+		var nodes []subcategoryNode
+		if nodes, err = b.subcategories(ctx, bson.M{"scid": id}); len(nodes) > 0 {
+			result = nodes[0]
+		}
+	}
+	if err == nil && result == nil {
+		err = ErrNotFound
+	}
+	if err != nil {
+		storeError(w, r, err) // 404 also when the root is inactive and active=true
+		return
+	}
+	writeJSON(w, http.StatusOK, result)
+}
@@ -2,233 +2,138 @@ package app
 
 import (
 	"context"
-	"encoding/json"
-	"fmt"
-	"log"
+	"errors"
 	"net/http"
-	"regexp"
+	"time"
 
-	"github.com/go-playground/validator/v10"
 	"github.com/gorilla/mux"
-	"go.mongodb.org/mongo-driver/bson"
-	"go.mongodb.org/mongo-driver/bson/primitive"
-	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
 // struct for storing data ,min=3,max=20,required
 type category struct {
-	CId         string `json:"cid" validate:"required,alphanum,min=4,max=10"`
-	Cname       string `json:"cname" validate:"required,min=3,max=20"`
-	Cdesc       string `json:"cdesc" validate:"required,min=5,max=100"`
-	Ccreatedby  string `json:"ccreatedby" validate:"required,min=3,max=20"`
-	Cmodifiedby string `json:"cmodifiedby" validate:"required,min=3,max=20"`
-	Cstatus     bool   `json:"cstatus"`
+	CId         string    `json:"cid" validate:"omitempty,alphanum,min=4,max=26"`
+	CParent     string    `json:"cparent" validate:"omitempty,alphanum,min=4,max=26"` // empty for a top-level category
+	CPath       []string  `json:"cpath"`                                              // ancestors from the top down, kept by the server
+	Cname       string    `json:"cname" validate:"required,min=3,max=20"`
+	Cdesc       string    `json:"cdesc" validate:"required,min=5,max=100"`
+	Ccreatedby  string    `json:"ccreatedby" validate:"required,min=3,max=20"`
+	Cmodifiedby string    `json:"cmodifiedby" validate:"required,min=3,max=20"`
+	Cstatus     bool      `json:"cstatus"`
+	Version     int64     `json:"version"`                    // one more on every write; sent as the ETag
+	CreatedAt   time.Time `json:"createdAt" bson:"createdAt"` // set by the server
+	UpdatedAt   time.Time `json:"updatedAt" bson:"updatedAt"`
+	CreatedBy   string    `json:"createdBy,omitempty" bson:"createdBy,omitempty"` // the user named by the actor header, if any
+	UpdatedBy   string    `json:"updatedBy,omitempty" bson:"updatedBy,omitempty"`
 }
 
-var categoryCollection = db().Database("ProductApp").Collection("Category") // get collection "users" from db() which returns *mongo.Client
+// struct for updating data
+type categoryUpdate struct {
+	CId         string `json:"cid" validate:"required,alphanum,min=4,max=26"` //value that has to be matched
+	Cname       string `json:"cname" validate:"required,min=3,max=20"`        // value that has to be modified
+	Cdesc       string `json:"cdesc" validate:"required,min=5,max=100"`       // value that has to be modified
+	Cmodifiedby string `json:"cmodifiedby" validate:"required,min=3,max=20"`  // value that has to be modified
+}
 
 // Create Category
 
-func CreateCategory(w http.ResponseWriter, r *http.Request) {
-	
-	w.Header().Set("Content-Type", "application/json") // for adding Content-type
-	validate := validator.New()
+func (h *Handler) CreateCategory(w http.ResponseWriter, r *http.Request) {
 	var cat category
-	err := json.NewDecoder(r.Body).Decode(&cat) // storing in category variable of type cat
-	if err != nil {
-		fmt.Print(err)
-		w.WriteHeader(400)
+	if !decodeBody(w, r, &cat) || !validateBody(w, r, cat) { //create struct validation
+		return
 	}
-	errv := validate.Struct(cat) //create struct validation
-	if errv != nil {
-		fmt.Println(errv)
-		w.WriteHeader(401)
-		json.NewEncoder(w).Encode("Validation error")
-	} else {
-		count, errc := categoryCollection.CountDocuments(context.TODO(), bson.D{{"cid", cat.CId}})
-		fmt.Println("check count of cid:", count)
-		if errc != nil {
-			log.Fatal(errc)
-		} else {
-			if count == 0 {
-				insertResult, err := categoryCollection.InsertOne(context.TODO(), cat)
-				if err != nil {
-					log.Fatal(err)
-					w.WriteHeader(500)
-				} else {
-					fmt.Println("Inserted a single document: ", insertResult)
-					json.NewEncoder(w).Encode(insertResult.InsertedID) // return the mongodb ID of generated document
-					w.WriteHeader(201)
-				}
-			} else {
-				json.NewEncoder(w).Encode("Dupilicate Category!")
+	if !h.newID(w, r, categoryIDs, &cat.CId, h.store.Categories.Exists) {
+		return
+	}
+	var created category
+	err := h.store.Tx.Run(r.Context(), func(ctx context.Context) error {
+		cat.CPath = []string{}
+		if cat.CParent != "" { // a category nested under another
+			parent, err := h.store.Categories.Get(ctx, cat.CParent)
+			if errors.Is(err, ErrNotFound) {
+				return requestError{http.StatusUnprocessableEntity, codeInvalidReference, "Invalid Category Id", []fieldError{{Field: "cparent", Message: "no such document"}}}
+			} else if err != nil {
+				return err
 			}
+			cat.CPath = childPath(parent)
+		}
+		if _, err := h.store.Categories.Insert(ctx, cat); err != nil {
+			return err
 		}
+		var err error
+		created, err = h.store.Categories.Get(ctx, cat.CId) // as stored, with its version and stamps
+		return err
+	})
+	if err != nil {
+		storeError(w, r, err)
+		return
 	}
+	logInfof("inserted a single document: %v", created.CId)
+	w.Header().Set("Location", "/api/GetCategory/"+created.CId)
+	writeVersioned(w, http.StatusCreated, created) // return the created document with its ETag
 }
 
 // Get Category
 
-func GetCategory(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Content-Type", "application/json")
+func (h *Handler) GetCategory(w http.ResponseWriter, r *http.Request) {
 	params := mux.Vars(r)["id"] //get Parameter value as string
-	is_alphanumeric := regexp.MustCompile(`^[a-zA-Z0-9]*$`).MatchString(params)
-	// var body category
-	// e := json.NewDecoder(r.Body).Decode(&body)
-	// if e != nil {
-	// 	fmt.Print(e)
-	// }
-	if is_alphanumeric {
-		var result primitive.M //  an unordered representation of a BSON document which is a Map
-		filter := bson.M{"cid": params}
-		err := categoryCollection.FindOne(context.TODO(), filter).Decode(&result)
-		if err != nil {
-
-			fmt.Println(err)
-			w.WriteHeader(204)
-			json.NewEncoder(w).Encode("No data found!")
-		} else {
-			json.NewEncoder(w).Encode(result) // returns a Map containing document
-			w.WriteHeader(200)
-		}
-	} else {
-		json.NewEncoder(w).Encode("Please enter the correct Category ID!")
-		w.WriteHeader(204)
+	if !validID(w, r, params, "Category") {
+		return
+	}
+	result, err := h.store.Categories.Get(r.Context(), params)
+	if err != nil {
+		storeError(w, r, err)
+		return
 	}
+	writeVersioned(w, http.StatusOK, result) // returns the document with its ETag
 }
 
 // Get All Category
 
-func GetAllCategory(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Content-Type", "application/json")
-	var results []primitive.M                                       //slice for multiple documents
-	cur, err := categoryCollection.Find(context.TODO(), bson.D{{}}) //returns a *mongo.Cursor
-	if err != nil {
-		fmt.Println(err)
-		w.WriteHeader(400)
-	}
-	for cur.Next(context.TODO()) { //Next() gets the next document for corresponding cursor
-		var elem primitive.M
-		err := cur.Decode(&elem)
-		if err != nil {
-			log.Fatal(err)
-			w.WriteHeader(500)
-		}
-		results = append(results, elem) // appending document pointed by Next()
-	}
-	cur.Close(context.TODO()) // close the cursor once stream of documents has exhausted
-	json.NewEncoder(w).Encode(results)
-	w.WriteHeader(200)
+func (h *Handler) GetAllCategory(w http.ResponseWriter, r *http.Request) {
+	serveList[category](w, r, h.store.Categories, "cid", categoryFilters, nil)
 }
 
 //Update Category
 
-func UpdateCategory(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Content-Type", "application/json")
-	validate := validator.New()
-
-	type updateBody struct {
-		CId         string `json:"cid" validate:"required,alphanum,min=4,max=10"` //value that has to be matched
-		Cname       string `json:"cname" validate:"required,min=3,max=20"`        // value that has to be modified
-		Cdesc       string `json:"cdesc" validate:"required,min=5,max=100"`       // value that has to be modified
-		Cmodifiedby string `json:"cmodifiedby" validate:"required,min=3,max=20"`  // value that has to be modified
+func (h *Handler) UpdateCategory(w http.ResponseWriter, r *http.Request) {
+	var body categoryUpdate
+	if !decodeBody(w, r, &body) || !validateBody(w, r, body) { // update struct validation
+		return
 	}
-	var body updateBody
-	e := json.NewDecoder(r.Body).Decode(&body)
-	if e != nil {
-
-		fmt.Print(e)
-		w.WriteHeader(400)
+	version, ok := ifMatch(w, r)
+	if !ok {
+		return
 	}
-	errv := validate.Struct(body) // update struct validation
-	if errv != nil {
-		fmt.Println(errv)
-		w.WriteHeader(401)
-		json.NewEncoder(w).Encode("Validation error")
-	} else {
-		filter := bson.D{{"cid", body.CId}} // converting value to BSON type
-		after := options.After              // for returning updated document
-		returnOpt := options.FindOneAndUpdateOptions{
-
-			ReturnDocument: &after,
-		}
-		update := bson.D{{"$set", bson.D{{"cname", body.Cname}, {"cdesc", body.Cdesc}, {"cmodifiedby", body.Cmodifiedby}}}}
-		updateResult := categoryCollection.FindOneAndUpdate(context.TODO(), filter, update, &returnOpt)
-
-		var result primitive.M
-		_ = updateResult.Decode(&result)
-
-		json.NewEncoder(w).Encode(result)
-		w.WriteHeader(200)
+	result, err := h.store.Categories.Update(r.Context(), body, version)
+	if err != nil {
+		storeError(w, r, err)
+		return
 	}
+	writeVersioned(w, http.StatusOK, result)
 }
 
 // Update Category Status
 
-func UpdateCategoryStatus(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Content-Type", "application/json")
-	validate := validator.New()
-
+func (h *Handler) UpdateCategoryStatus(w http.ResponseWriter, r *http.Request) {
 	type updateBody struct {
-		CId     string `json:"cid" validate:"required,alphanum,min=4,max=10"` //value that has to be matched
+		CId     string `json:"cid" validate:"required,alphanum,min=4,max=26"` //value that has to be matched
 		Cstatus bool   `json:"cstatus"`                                       // value that has to be modified
 	}
 	var body updateBody
-	e := json.NewDecoder(r.Body).Decode(&body)
-	if e != nil {
-
-		fmt.Print(e)
-		w.WriteHeader(400)
+	if !decodeBody(w, r, &body) || !validateBody(w, r, body) { // update status struct validation
+		return
 	}
-	errv := validate.Struct(body) // update status struct validation
-	if errv != nil {
-		fmt.Println(errv)
-		w.WriteHeader(401)
-		json.NewEncoder(w).Encode("Validation error")
-	} else {
-		filter := bson.D{{"cid", body.CId}} // converting value to BSON type
-		after := options.After              // for returning updated document
-		returnOpt := options.FindOneAndUpdateOptions{
-
-			ReturnDocument: &after,
-		}
-		update := bson.D{{"$set", bson.D{{"cstatus", body.Cstatus}}}}
-		updateResult := categoryCollection.FindOneAndUpdate(context.TODO(), filter, update, &returnOpt)
-
-		var result primitive.M
-		_ = updateResult.Decode(&result)
-
-		json.NewEncoder(w).Encode(result)
-		w.WriteHeader(200)
-	}
-
+	h.updateStatus(w, r, "category", body.CId, body.Cstatus, func(ctx context.Context, version int64) (versioned, error) { // ?cascade=true also updates the subtree
+		return h.store.Categories.UpdateStatus(ctx, body.CId, body.Cstatus, version)
+	})
 }
 
 //Delete Category
 
-func DeleteCategory(w http.ResponseWriter, r *http.Request) {
-
-	w.Header().Set("Content-Type", "application/json")
+func (h *Handler) DeleteCategory(w http.ResponseWriter, r *http.Request) {
 	params := mux.Vars(r)["id"] //get Parameter value as string
-	is_alphanumeric := regexp.MustCompile(`^[a-zA-Z0-9]*$`).MatchString(params)
-	// _id, err := primitive.ObjectIDFromHex(params) // convert params to mongodb Hex ID
-	// if err != nil {
-	// 	fmt.Printf(err.Error())
-	// }
-	if is_alphanumeric {
-		opts := options.Delete().SetCollation(&options.Collation{}) // to specify language-specific rules for string comparison, such as rules for lettercase
-		res, err := categoryCollection.DeleteOne(context.TODO(), bson.D{{"cid", params}}, opts)
-		if err != nil {
-			log.Fatal(err)
-			w.WriteHeader(400)
-		} else {
-			fmt.Printf("deleted %v documents\n", res.DeletedCount)
-			json.NewEncoder(w).Encode(res.DeletedCount) // return number of documents deleted
-			w.WriteHeader(200)
-		}
-	} else {
-		json.NewEncoder(w).Encode("Please enter the correct Category ID!")
-		w.WriteHeader(204)
+	if !validID(w, r, params, "Category") {
+		return
 	}
-
+	h.deleteWithPolicy(w, r, "category", params)
 }
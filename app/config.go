@@ -0,0 +1,455 @@
+package app
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Config holds every setting the service reads at startup.
+//
+// Values are resolved in this order, later sources overriding earlier ones:
+// built-in defaults, the config file (YAML or JSON, given by -config or
+// PRODUCTAPP_CONFIG), PRODUCTAPP_* environment variables, command-line flags.
+type Config struct {
+	Store    string         `yaml:"store"` // mongo or memory
+	Mongo    MongoConfig    `yaml:"mongo"`
+	Server   ServerConfig   `yaml:"server"`
+	Delete   DeletePolicies `yaml:"delete"`
+	IDs      IDConfig       `yaml:"ids"`
+	Auth     AuthConfig     `yaml:"auth"`
+	Suggest  SuggestConfig  `yaml:"suggest"`
+	LogLevel string         `yaml:"logLevel"`
+}
+
+// MongoConfig describes where the Mongo store keeps its data.
+type MongoConfig struct {
+	URI             string          `yaml:"uri"`
+	Database        string          `yaml:"database"`
+	Collections     CollectionNames `yaml:"collections"`
+	ConnectTimeout  time.Duration   `yaml:"connectTimeout"`
+	ConnectAttempts int             `yaml:"connectAttempts"` // tries before giving up at startup
+	RetryBackoff    time.Duration   `yaml:"retryBackoff"`    // wait after the first failed try, doubled each time
+	MaxRetryBackoff time.Duration   `yaml:"maxRetryBackoff"`
+	// AllowNonTransactional lets the app run on a standalone server, where
+	// writes spanning several documents are not atomic.
+	AllowNonTransactional bool `yaml:"allowNonTransactional"`
+	// DropMigrationBackup drops the old subcategory collection, which the
+	// migration to nested categories keeps under a backup name.
+	DropMigrationBackup bool `yaml:"dropMigrationBackup"`
+}
+
+// CollectionNames maps each entity to its Mongo collection.
+type CollectionNames struct {
+	Product         string `yaml:"product"`
+	Category        string `yaml:"category"`
+	SubCategory     string `yaml:"subcategory"` // only read once, to move subcategories into Category
+	Brand           string `yaml:"brand"`
+	BrandAssignment string `yaml:"brandAssignment"` // which subcategories each brand sells in
+	Varient         string `yaml:"varient"`
+	StatusRecord    string `yaml:"statusRecord"` // statuses saved by cascading deactivations
+	Counter         string `yaml:"counter"`      // sequences behind server-generated IDs
+	Search          string `yaml:"search"`       // product search index
+	Audit           string `yaml:"audit"`        // log of every write to the catalog
+}
+
+// ServerConfig holds the HTTP listener settings.
+type ServerConfig struct {
+	Addr            string        `yaml:"addr"`
+	ReadTimeout     time.Duration `yaml:"readTimeout"`
+	WriteTimeout    time.Duration `yaml:"writeTimeout"`
+	IdleTimeout     time.Duration `yaml:"idleTimeout"`
+	ShutdownTimeout time.Duration `yaml:"shutdownTimeout"` // grace period for in-flight requests on exit
+}
+
+// DeletePolicies says what deleting a document does to the documents that
+// reference it, per relationship: restrict refuses the delete while any
+// exist, cascade deletes them too and deactivate switches their status off.
+type DeletePolicies struct {
+	CategorySubCategories string `yaml:"categorySubcategories"` // the categories directly below, at any level
+	CategoryBrands        string `yaml:"categoryBrands"`        // brand assignments, see brandAssignment; restrict or cascade only
+	CategoryProducts      string `yaml:"categoryProducts"`
+	SubCategoryBrands     string `yaml:"subcategoryBrands"` // restrict or cascade only
+	SubCategoryProducts   string `yaml:"subcategoryProducts"`
+	BrandProducts         string `yaml:"brandProducts"`
+	VarientProducts       string `yaml:"varientProducts"`
+}
+
+// IDConfig says how new documents get their ID when the create request
+// leaves it out: a prefix and a zero-padded sequence number (P00001), or a
+// ULID. Sequences are kept per entity in the counter collection.
+type IDConfig struct {
+	Generate    string     `yaml:"generate"`    // sequence or ulid
+	AllowClient bool       `yaml:"allowClient"` // accept IDs sent in create requests
+	Width       int        `yaml:"width"`       // digits of a sequence number
+	Prefixes    IDPrefixes `yaml:"prefixes"`
+}
+
+// IDPrefixes is the prefix of sequence IDs, per entity.
+type IDPrefixes struct {
+	Product     string `yaml:"product"`
+	Category    string `yaml:"category"`
+	SubCategory string `yaml:"subcategory"`
+	Brand       string `yaml:"brand"`
+	Varient     string `yaml:"varient"`
+}
+
+// AuthConfig says where the user making a request is named. The service
+// does not authenticate users itself; an authenticating proxy in front of it
+// sets ActorHeader, and documents record that user as createdBy and updatedBy.
+type AuthConfig struct {
+	ActorHeader string `yaml:"actorHeader"` // e.g. X-Forwarded-User; empty records no user
+}
+
+// SuggestConfig says how often the Mongo store reloads the typeahead
+// suggestions from the database, to take in the writes of other instances.
+type SuggestConfig struct {
+	RefreshInterval time.Duration `yaml:"refreshInterval"` // 0 loads them at startup only
+}
+
+// DefaultConfig returns the settings used when nothing else is configured.
+func DefaultConfig() Config {
+	return Config{
+		Store: "mongo",
+		Mongo: MongoConfig{
+			URI:      "mongodb://localhost:27017",
+			Database: "ProductApp",
+			Collections: CollectionNames{
+				Product:         "Product",
+				Category:        "Category",
+				SubCategory:     "Subcategory",
+				Brand:           "Brand",
+				BrandAssignment: "BrandAssignment",
+				Varient:         "Varient",
+				StatusRecord:    "StatusRecord",
+				Counter:         "Counter",
+				Search:          "ProductSearch",
+				Audit:           "Audit",
+			},
+			ConnectTimeout:  10 * time.Second,
+			ConnectAttempts: 5,
+			RetryBackoff:    time.Second,
+			MaxRetryBackoff: 30 * time.Second,
+		},
+		Server: ServerConfig{
+			Addr:            ":8000",
+			ReadTimeout:     15 * time.Second,
+			WriteTimeout:    15 * time.Second,
+			IdleTimeout:     60 * time.Second,
+			ShutdownTimeout: 10 * time.Second,
+		},
+		Delete: DeletePolicies{
+			CategorySubCategories: policyRestrict,
+			CategoryBrands:        policyRestrict,
+			CategoryProducts:      policyRestrict,
+			SubCategoryBrands:     policyRestrict,
+			SubCategoryProducts:   policyRestrict,
+			BrandProducts:         policyRestrict,
+			VarientProducts:       policyRestrict,
+		},
+		IDs: IDConfig{
+			Generate:    idSequence,
+			AllowClient: true,
+			Width:       5,
+			Prefixes:    IDPrefixes{Product: "P", Category: "C", SubCategory: "SC", Brand: "B", Varient: "V"},
+		},
+		Suggest:  SuggestConfig{RefreshInterval: time.Minute},
+		LogLevel: "info",
+	}
+}
+
+// LoadConfig resolves the configuration from defaults, config file,
+// environment and the given command-line arguments, then validates it.
+// It returns flag.ErrHelp when the arguments ask for the usage.
+func LoadConfig(args []string) (Config, error) {
+	cfg := DefaultConfig()
+
+	// flags are parsed first so -config is known, but applied last
+	var flagCfg Config
+	var configPath string
+	fs := flag.NewFlagSet("ProductApp", flag.ContinueOnError)
+	fs.StringVar(&configPath, "config", "", "path to a YAML or JSON config file (env PRODUCTAPP_CONFIG)")
+	fs.StringVar(&flagCfg.Store, "store", "", "storage backend: mongo or memory")
+	fs.StringVar(&flagCfg.Mongo.URI, "mongo-uri", "", "MongoDB connection string")
+	fs.StringVar(&flagCfg.Mongo.Database, "mongo-database", "", "MongoDB database name")
+	fs.DurationVar(&flagCfg.Mongo.ConnectTimeout, "mongo-connect-timeout", 0, "timeout for connecting to MongoDB")
+	fs.IntVar(&flagCfg.Mongo.ConnectAttempts, "mongo-connect-attempts", 0, "connection attempts to MongoDB at startup")
+	fs.StringVar(&flagCfg.Server.Addr, "addr", "", "HTTP listen address")
+	fs.DurationVar(&flagCfg.Server.ReadTimeout, "read-timeout", 0, "HTTP read timeout")
+	fs.DurationVar(&flagCfg.Server.WriteTimeout, "write-timeout", 0, "HTTP write timeout")
+	fs.DurationVar(&flagCfg.Server.IdleTimeout, "idle-timeout", 0, "HTTP keep-alive idle timeout")
+	fs.DurationVar(&flagCfg.Server.ShutdownTimeout, "shutdown-timeout", 0, "grace period for in-flight requests on shutdown")
+	fs.StringVar(&flagCfg.LogLevel, "log-level", "", "log level: debug, info, warn or error")
+	if err := fs.Parse(args); err != nil {
+		return cfg, err
+	}
+
+	var problems []string
+	if configPath == "" {
+		configPath = os.Getenv("PRODUCTAPP_CONFIG")
+	}
+	if configPath != "" {
+		data, err := os.ReadFile(configPath)
+		if err != nil {
+			return cfg, fmt.Errorf("reading config file: %w", err)
+		}
+		// JSON is a subset of YAML, so one decoder handles both formats
+		dec := yaml.NewDecoder(bytes.NewReader(data))
+		dec.KnownFields(true) // a misspelt key is an error rather than a silent default
+		var typeErr *yaml.TypeError
+		if err := dec.Decode(&cfg); errors.As(err, &typeErr) {
+			for _, e := range typeErr.Errors {
+				problems = append(problems, fmt.Sprintf("config file %s: %s", configPath, e))
+			}
+		} else if err != nil && !errors.Is(err, io.EOF) {
+			problems = append(problems, fmt.Sprintf("config file %s: %v", configPath, err))
+		}
+	}
+
+	problems = append(problems, applyEnv(&cfg)...)
+
+	fs.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "store":
+			cfg.Store = flagCfg.Store
+		case "mongo-uri":
+			cfg.Mongo.URI = flagCfg.Mongo.URI
+		case "mongo-database":
+			cfg.Mongo.Database = flagCfg.Mongo.Database
+		case "mongo-connect-timeout":
+			cfg.Mongo.ConnectTimeout = flagCfg.Mongo.ConnectTimeout
+		case "mongo-connect-attempts":
+			cfg.Mongo.ConnectAttempts = flagCfg.Mongo.ConnectAttempts
+		case "addr":
+			cfg.Server.Addr = flagCfg.Server.Addr
+		case "read-timeout":
+			cfg.Server.ReadTimeout = flagCfg.Server.ReadTimeout
+		case "write-timeout":
+			cfg.Server.WriteTimeout = flagCfg.Server.WriteTimeout
+		case "idle-timeout":
+			cfg.Server.IdleTimeout = flagCfg.Server.IdleTimeout
+		case "shutdown-timeout":
+			cfg.Server.ShutdownTimeout = flagCfg.Server.ShutdownTimeout
+		case "log-level":
+			cfg.LogLevel = flagCfg.LogLevel
+		}
+	})
+
+	problems = append(problems, cfg.validate()...)
+	if len(problems) > 0 {
+		return cfg, ConfigError(problems)
+	}
+	return cfg, nil
+}
+
+// applyEnv overrides cfg with the PRODUCTAPP_* variables that are set and
+// returns a problem for each one that cannot be parsed.
+func applyEnv(cfg *Config) []string {
+	var problems []string
+	str := func(name string, dst *string) {
+		if v, ok := os.LookupEnv(name); ok {
+			*dst = v
+		}
+	}
+	dur := func(name string, dst *time.Duration) {
+		if v, ok := os.LookupEnv(name); ok {
+			d, err := time.ParseDuration(v)
+			if err != nil {
+				problems = append(problems, fmt.Sprintf("%s: %q is not a duration", name, v))
+				return
+			}
+			*dst = d
+		}
+	}
+	boolean := func(name string, dst *bool) {
+		if v, ok := os.LookupEnv(name); ok {
+			b, err := strconv.ParseBool(v)
+			if err != nil {
+				problems = append(problems, fmt.Sprintf("%s: %q is not true or false", name, v))
+				return
+			}
+			*dst = b
+		}
+	}
+	num := func(name string, dst *int) {
+		if v, ok := os.LookupEnv(name); ok {
+			n, err := strconv.Atoi(v)
+			if err != nil {
+				problems = append(problems, fmt.Sprintf("%s: %q is not a number", name, v))
+				return
+			}
+			*dst = n
+		}
+	}
+	str("PRODUCTAPP_STORE", &cfg.Store)
+	str("PRODUCTAPP_MONGO_URI", &cfg.Mongo.URI)
+	str("PRODUCTAPP_MONGO_DATABASE", &cfg.Mongo.Database)
+	dur("PRODUCTAPP_MONGO_CONNECT_TIMEOUT", &cfg.Mongo.ConnectTimeout)
+	num("PRODUCTAPP_MONGO_CONNECT_ATTEMPTS", &cfg.Mongo.ConnectAttempts)
+	dur("PRODUCTAPP_MONGO_RETRY_BACKOFF", &cfg.Mongo.RetryBackoff)
+	dur("PRODUCTAPP_MONGO_MAX_RETRY_BACKOFF", &cfg.Mongo.MaxRetryBackoff)
+	boolean("PRODUCTAPP_MONGO_ALLOW_NON_TRANSACTIONAL", &cfg.Mongo.AllowNonTransactional)
+	boolean("PRODUCTAPP_MONGO_DROP_MIGRATION_BACKUP", &cfg.Mongo.DropMigrationBackup)
+	str("PRODUCTAPP_COLLECTION_PRODUCT", &cfg.Mongo.Collections.Product)
+	str("PRODUCTAPP_COLLECTION_CATEGORY", &cfg.Mongo.Collections.Category)
+	str("PRODUCTAPP_COLLECTION_SUBCATEGORY", &cfg.Mongo.Collections.SubCategory)
+	str("PRODUCTAPP_COLLECTION_BRAND", &cfg.Mongo.Collections.Brand)
+	str("PRODUCTAPP_COLLECTION_BRAND_ASSIGNMENT", &cfg.Mongo.Collections.BrandAssignment)
+	str("PRODUCTAPP_COLLECTION_VARIENT", &cfg.Mongo.Collections.Varient)
+	str("PRODUCTAPP_COLLECTION_STATUS_RECORD", &cfg.Mongo.Collections.StatusRecord)
+	str("PRODUCTAPP_COLLECTION_COUNTER", &cfg.Mongo.Collections.Counter)
+	str("PRODUCTAPP_COLLECTION_SEARCH", &cfg.Mongo.Collections.Search)
+	str("PRODUCTAPP_COLLECTION_AUDIT", &cfg.Mongo.Collections.Audit)
+	str("PRODUCTAPP_ADDR", &cfg.Server.Addr)
+	dur("PRODUCTAPP_READ_TIMEOUT", &cfg.Server.ReadTimeout)
+	dur("PRODUCTAPP_WRITE_TIMEOUT", &cfg.Server.WriteTimeout)
+	dur("PRODUCTAPP_IDLE_TIMEOUT", &cfg.Server.IdleTimeout)
+	dur("PRODUCTAPP_SHUTDOWN_TIMEOUT", &cfg.Server.ShutdownTimeout)
+	str("PRODUCTAPP_DELETE_CATEGORY_SUBCATEGORIES", &cfg.Delete.CategorySubCategories)
+	str("PRODUCTAPP_DELETE_CATEGORY_BRANDS", &cfg.Delete.CategoryBrands)
+	str("PRODUCTAPP_DELETE_CATEGORY_PRODUCTS", &cfg.Delete.CategoryProducts)
+	str("PRODUCTAPP_DELETE_SUBCATEGORY_BRANDS", &cfg.Delete.SubCategoryBrands)
+	str("PRODUCTAPP_DELETE_SUBCATEGORY_PRODUCTS", &cfg.Delete.SubCategoryProducts)
+	str("PRODUCTAPP_DELETE_BRAND_PRODUCTS", &cfg.Delete.BrandProducts)
+	str("PRODUCTAPP_DELETE_VARIENT_PRODUCTS", &cfg.Delete.VarientProducts)
+	str("PRODUCTAPP_IDS_GENERATE", &cfg.IDs.Generate)
+	boolean("PRODUCTAPP_IDS_ALLOW_CLIENT", &cfg.IDs.AllowClient)
+	num("PRODUCTAPP_IDS_WIDTH", &cfg.IDs.Width)
+	str("PRODUCTAPP_IDS_PREFIX_PRODUCT", &cfg.IDs.Prefixes.Product)
+	str("PRODUCTAPP_IDS_PREFIX_CATEGORY", &cfg.IDs.Prefixes.Category)
+	str("PRODUCTAPP_IDS_PREFIX_SUBCATEGORY", &cfg.IDs.Prefixes.SubCategory)
+	str("PRODUCTAPP_IDS_PREFIX_BRAND", &cfg.IDs.Prefixes.Brand)
+	str("PRODUCTAPP_IDS_PREFIX_VARIENT", &cfg.IDs.Prefixes.Varient)
+	str("PRODUCTAPP_AUTH_ACTOR_HEADER", &cfg.Auth.ActorHeader)
+	dur("PRODUCTAPP_SUGGEST_REFRESH_INTERVAL", &cfg.Suggest.RefreshInterval)
+	str("PRODUCTAPP_LOG_LEVEL", &cfg.LogLevel)
+	return problems
+}
+
+// validate returns one message per invalid setting.
+func (cfg Config) validate() []string {
+	var problems []string
+	switch cfg.Store {
+	case "mongo":
+		if !strings.HasPrefix(cfg.Mongo.URI, "mongodb://") && !strings.HasPrefix(cfg.Mongo.URI, "mongodb+srv://") {
+			problems = append(problems, "mongo.uri must start with mongodb:// or mongodb+srv://")
+		}
+		if cfg.Mongo.Database == "" || strings.ContainsAny(cfg.Mongo.Database, `/\. "$`) {
+			problems = append(problems, fmt.Sprintf("mongo.database %q is not a valid database name", cfg.Mongo.Database))
+		}
+		names := []struct{ key, name string }{
+			{"product", cfg.Mongo.Collections.Product},
+			{"category", cfg.Mongo.Collections.Category},
+			{"subcategory", cfg.Mongo.Collections.SubCategory},
+			{"brand", cfg.Mongo.Collections.Brand},
+			{"brandAssignment", cfg.Mongo.Collections.BrandAssignment},
+			{"varient", cfg.Mongo.Collections.Varient},
+			{"statusRecord", cfg.Mongo.Collections.StatusRecord},
+			{"counter", cfg.Mongo.Collections.Counter},
+			{"search", cfg.Mongo.Collections.Search},
+			{"audit", cfg.Mongo.Collections.Audit},
+		}
+		for _, c := range names {
+			if c.name == "" || strings.HasPrefix(c.name, "system.") || strings.Contains(c.name, "$") {
+				problems = append(problems, fmt.Sprintf("mongo.collections.%s %q is not a valid collection name", c.key, c.name))
+			}
+		}
+		if cfg.Mongo.ConnectTimeout <= 0 {
+			problems = append(problems, "mongo.connectTimeout must be positive")
+		}
+		if cfg.Mongo.ConnectAttempts < 1 {
+			problems = append(problems, "mongo.connectAttempts must be at least 1")
+		}
+		if cfg.Mongo.RetryBackoff <= 0 || cfg.Mongo.MaxRetryBackoff < cfg.Mongo.RetryBackoff {
+			problems = append(problems, "mongo.retryBackoff must be positive and not above mongo.maxRetryBackoff")
+		}
+	case "memory":
+	default:
+		problems = append(problems, fmt.Sprintf("store %q must be mongo or memory", cfg.Store))
+	}
+
+	if _, port, err := net.SplitHostPort(cfg.Server.Addr); err != nil {
+		problems = append(problems, fmt.Sprintf("server.addr %q is not a host:port address", cfg.Server.Addr))
+	} else if n, err := strconv.Atoi(port); err != nil || n < 0 || n > 65535 {
+		problems = append(problems, fmt.Sprintf("server.addr %q has an invalid port", cfg.Server.Addr))
+	}
+	if cfg.Server.ReadTimeout <= 0 {
+		problems = append(problems, "server.readTimeout must be positive")
+	}
+	if cfg.Server.WriteTimeout <= 0 {
+		problems = append(problems, "server.writeTimeout must be positive")
+	}
+	if cfg.Server.IdleTimeout <= 0 {
+		problems = append(problems, "server.idleTimeout must be positive")
+	}
+	if cfg.Server.ShutdownTimeout <= 0 {
+		problems = append(problems, "server.shutdownTimeout must be positive")
+	}
+	policies := []struct {
+		key, value  string
+		assignments bool // brand assignments have no status to switch off
+	}{
+		{"categorySubcategories", cfg.Delete.CategorySubCategories, false},
+		{"categoryBrands", cfg.Delete.CategoryBrands, true},
+		{"categoryProducts", cfg.Delete.CategoryProducts, false},
+		{"subcategoryBrands", cfg.Delete.SubCategoryBrands, true},
+		{"subcategoryProducts", cfg.Delete.SubCategoryProducts, false},
+		{"brandProducts", cfg.Delete.BrandProducts, false},
+		{"varientProducts", cfg.Delete.VarientProducts, false},
+	}
+	for _, p := range policies {
+		switch {
+		case p.assignments && p.value == policyDeactivate:
+			problems = append(problems, fmt.Sprintf("delete.%s %q is not supported: brand assignments have no status, use restrict or cascade", p.key, p.value))
+		case p.value == policyRestrict, p.value == policyCascade, p.value == policyDeactivate:
+		default:
+			problems = append(problems, fmt.Sprintf("delete.%s %q must be restrict, cascade or deactivate", p.key, p.value))
+		}
+	}
+	switch cfg.IDs.Generate {
+	case idSequence:
+		if cfg.IDs.Width < 1 {
+			problems = append(problems, "ids.width must be at least 1")
+		}
+		prefixes := []struct{ key, value string }{
+			{"product", cfg.IDs.Prefixes.Product},
+			{"category", cfg.IDs.Prefixes.Category},
+			{"subcategory", cfg.IDs.Prefixes.SubCategory},
+			{"brand", cfg.IDs.Prefixes.Brand},
+			{"varient", cfg.IDs.Prefixes.Varient},
+		}
+		for _, p := range prefixes {
+			if !alphanumeric.MatchString(p.value) {
+				problems = append(problems, fmt.Sprintf("ids.prefixes.%s %q must be letters and digits", p.key, p.value))
+			} else if n := len(p.value) + cfg.IDs.Width; cfg.IDs.Width >= 1 && (n < minIDLength || n > maxIDLength) {
+				problems = append(problems, fmt.Sprintf("ids.prefixes.%s with ids.width %d gives %d-character IDs, not %d to %d", p.key, cfg.IDs.Width, n, minIDLength, maxIDLength))
+			}
+		}
+	case idULID:
+	default:
+		problems = append(problems, fmt.Sprintf("ids.generate %q must be sequence or ulid", cfg.IDs.Generate))
+	}
+	if cfg.Suggest.RefreshInterval < 0 {
+		problems = append(problems, "suggest.refreshInterval must not be negative")
+	}
+	if _, ok := logLevels[strings.ToLower(cfg.LogLevel)]; !ok {
+		problems = append(problems, fmt.Sprintf("logLevel %q must be debug, info, warn or error", cfg.LogLevel))
+	}
+	return problems
+}
+
+// ConfigError lists every problem found while loading the configuration.
+type ConfigError []string
+
+func (e ConfigError) Error() string {
+	return "invalid configuration:\n  - " + strings.Join(e, "\n  - ")
+}
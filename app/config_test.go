@@ -0,0 +1,71 @@
+package app
+
+import (
+	"errors"
+	"flag"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestLoadConfigFile(t *testing.T) {
+	tests := []struct {
+		name     string
+		file     string
+		problems []string // of the ConfigError expected, FILE standing for the path
+	}{
+		{name: "empty", file: "\n"},
+		{name: "known keys", file: "store: memory\nmongo:\n  connectAttempts: 2\n"},
+		{name: "json", file: `{"store": "memory", "logLevel": "warn"}`},
+		{
+			name: "unknown keys",
+			file: "store: memory\nmongo:\n  urii: x\nlogLevl: warn\n",
+			problems: []string{
+				"config file FILE: line 3: field urii not found in type app.MongoConfig",
+				"config file FILE: line 4: field logLevl not found in type app.Config",
+			},
+		},
+		{name: "bad log level", file: "logLevel: loud\n", problems: []string{`logLevel "loud" must be debug, info, warn or error`}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "config.yaml")
+			if err := os.WriteFile(path, []byte(tt.file), 0o600); err != nil {
+				t.Fatal(err)
+			}
+			_, err := LoadConfig([]string{"-config", path})
+			if tt.problems == nil {
+				if err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+			var problems ConfigError
+			if !errors.As(err, &problems) {
+				t.Fatalf("error %v, want a ConfigError", err)
+			}
+			var want []string
+			for _, p := range tt.problems {
+				want = append(want, strings.ReplaceAll(p, "FILE", path))
+			}
+			if !reflect.DeepEqual([]string(problems), want) {
+				t.Errorf("problems %q, want %q", problems, want)
+			}
+		})
+	}
+}
+
+func TestLoadConfigFlags(t *testing.T) {
+	if _, err := LoadConfig([]string{"-h"}); !errors.Is(err, flag.ErrHelp) {
+		t.Errorf("-h: %v, want flag.ErrHelp", err)
+	}
+	var problems ConfigError
+	if _, err := LoadConfig([]string{"-bogus"}); err == nil || errors.As(err, &problems) {
+		t.Errorf("-bogus: %v, want the flag error", err)
+	}
+	if _, err := LoadConfig([]string{"-log-level", "loud"}); !errors.As(err, &problems) {
+		t.Errorf("-log-level loud: %v, want a ConfigError", err)
+	}
+}
@@ -0,0 +1,227 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+)
+
+// delete policies, see DeletePolicies
+const (
+	policyRestrict   = "restrict"
+	policyCascade    = "cascade"
+	policyDeactivate = "deactivate"
+)
+
+// relation is one reference from a child entity to its parent: the child's
+// field holds the parent's ID and policy picks the configured DeletePolicies value.
+type relation struct {
+	child  string
+	field  string
+	policy func(DeletePolicies) string
+}
+
+// categoryRelations point at a category node, whether it is reached as a
+// category or, having a parent, as a subcategory: its child categories, seen
+// through the subcategory view, the brand assignments to it and the products
+// naming it. brands picks the policy for the assignments.
+func categoryRelations(brands func(DeletePolicies) string) []relation {
+	return []relation{
+		{"subcategory", "cid", func(p DeletePolicies) string { return p.CategorySubCategories }},
+		{"assignment", "scid", brands},
+		{"product", "subprod.categoryid", func(p DeletePolicies) string { return p.CategoryProducts }},
+		{"product", "subprod.subcategoryid", func(p DeletePolicies) string { return p.SubCategoryProducts }},
+	}
+}
+
+// dependents lists, per entity, the relations pointing at it.
+var dependents = map[string][]relation{
+	"category":    categoryRelations(func(p DeletePolicies) string { return p.CategoryBrands }),
+	"subcategory": categoryRelations(func(p DeletePolicies) string { return p.SubCategoryBrands }),
+	"brand": {
+		{"assignment", "bid", func(DeletePolicies) string { return policyCascade }}, // part of the brand
+		{"product", "subprod.brandid", func(p DeletePolicies) string { return p.BrandProducts }},
+	},
+	"varient": {
+		{"product", "subprod.varientid", func(p DeletePolicies) string { return p.VarientProducts }},
+	},
+}
+
+// docRef names one document.
+type docRef struct {
+	Entity string `json:"entity"`
+	ID     string `json:"id"`
+}
+
+// blockedRef is a dependent that a restrict policy will not touch.
+type blockedRef struct {
+	docRef
+	References docRef `json:"references"`
+}
+
+// deletePlan is everything a delete would change. It is what a dry run returns.
+type deletePlan struct {
+	Deleted     []docRef     `json:"deleted"`     // the requested document first, then cascaded ones
+	Deactivated []docRef     `json:"deactivated"` // dependents whose status is switched off
+	Blocked     []blockedRef `json:"blocked"`     // dependents that make the delete fail
+}
+
+// planDelete walks the dependents of a document, following cascades, and
+// returns what deleting it would do under the given policies.
+func planDelete(ctx context.Context, s Stores, policies DeletePolicies, entity, id string) (deletePlan, error) {
+	plan := deletePlan{Deleted: []docRef{}, Deactivated: []docRef{}, Blocked: []blockedRef{}}
+	deleted := map[docRef]bool{}
+	deactivated := map[docRef]bool{}
+
+	var visit func(ref docRef) error
+	visit = func(ref docRef) error {
+		if deleted[ref] {
+			return nil
+		}
+		deleted[ref] = true
+		plan.Deleted = append(plan.Deleted, ref)
+		for _, rel := range dependents[ref.Entity] {
+			ids, err := entityStore(s, rel.child).Referencing(ctx, rel.field, ref.ID)
+			if err != nil {
+				return err
+			}
+			for _, childID := range ids {
+				child := docRef{rel.child, childID}
+				switch rel.policy(policies) {
+				case policyCascade:
+					if err := visit(child); err != nil {
+						return err
+					}
+				case policyDeactivate:
+					if !deactivated[child] {
+						deactivated[child] = true
+						plan.Deactivated = append(plan.Deactivated, child)
+					}
+				default:
+					plan.Blocked = append(plan.Blocked, blockedRef{child, ref})
+				}
+			}
+		}
+		return nil
+	}
+	if err := visit(docRef{entity, id}); err != nil {
+		return plan, err
+	}
+
+	// a document reached by a cascade is deleted, not deactivated
+	kept := plan.Deactivated[:0]
+	for _, ref := range plan.Deactivated {
+		if !deleted[ref] {
+			kept = append(kept, ref)
+		}
+	}
+	plan.Deactivated = kept
+	return plan, nil
+}
+
+// apply carries out the plan, deleting dependents before the documents they
+// reference, and returns the number of documents deleted. The requested
+// document is deleted at the given version.
+func (plan deletePlan) apply(ctx context.Context, s Stores, version int64) (int64, error) {
+	for _, ref := range plan.Deactivated {
+		if err := setStatus(ctx, s, ref, false); err != nil {
+			return 0, err
+		}
+	}
+	var total int64
+	for i := len(plan.Deleted) - 1; i >= 0; i-- {
+		ref, v := plan.Deleted[i], anyVersion
+		if i == 0 {
+			v = version
+		}
+		n, err := entityStore(s, ref.Entity).Delete(ctx, ref.ID, v)
+		if err != nil {
+			return total, err
+		}
+		total += n
+	}
+	return total, nil
+}
+
+// deletable is the part of every entity store the delete policies need.
+type deletable interface {
+	Exists(ctx context.Context, id string) (bool, error)
+	Referencing(ctx context.Context, field, id string) ([]string, error)
+	Delete(ctx context.Context, id string, version int64) (int64, error)
+}
+
+func entityStore(s Stores, entity string) deletable {
+	switch entity {
+	case "product":
+		return s.Products
+	case "category":
+		return s.Categories
+	case "subcategory":
+		return s.SubCategories
+	case "brand":
+		return s.Brands
+	case "assignment":
+		return s.BrandAssignments
+	default:
+		return s.Varients
+	}
+}
+
+// dryRunParam reads ?dryRun. On a bad value it answers 400 and returns false.
+func dryRunParam(w http.ResponseWriter, r *http.Request) (bool, bool) {
+	v := r.URL.Query().Get("dryRun")
+	if v == "" {
+		return false, true
+	}
+	dryRun, err := strconv.ParseBool(v)
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, codeBadRequest, "dryRun must be true or false")
+		return false, false
+	}
+	return dryRun, true
+}
+
+// deleteWithPolicy serves the delete endpoints of entities that other
+// documents reference. With ?dryRun=true it only reports the plan.
+func (h *Handler) deleteWithPolicy(w http.ResponseWriter, r *http.Request, entity, id string) {
+	ctx := r.Context()
+	dryRun, ok := dryRunParam(w, r)
+	if !ok {
+		return
+	}
+	version, ok := ifMatch(w, r)
+	if !ok {
+		return
+	}
+	var plan deletePlan
+	var deleted int64
+	err := h.store.Tx.Run(ctx, func(ctx context.Context) error { // no dependent can appear between plan and delete
+		err := requireVersion(ctx, h.store, docRef{entity, id}, version)
+		if err != nil {
+			return err
+		}
+		if plan, err = planDelete(ctx, h.store, h.app.Config().Delete, entity, id); err != nil || dryRun {
+			return err
+		}
+		if len(plan.Blocked) > 0 {
+			details := make([]fieldError, 0, len(plan.Blocked))
+			for _, b := range plan.Blocked {
+				details = append(details, fieldError{Field: b.Entity, Message: fmt.Sprintf("%s %s references %s %s", b.Entity, b.ID, b.References.Entity, b.References.ID)})
+			}
+			return requestError{http.StatusConflict, codeHasDependents, "Document is still referenced", details}
+		}
+		deleted, err = plan.apply(ctx, h.store, version)
+		return err
+	})
+	if err != nil {
+		storeError(w, r, err)
+		return
+	}
+	if dryRun {
+		writeJSON(w, http.StatusOK, plan)
+		return
+	}
+	logInfof("deleted %v documents, deactivated %v", deleted, len(plan.Deactivated))
+	writeJSON(w, http.StatusOK, deleted) // return number of documents deleted
+}
@@ -0,0 +1,148 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+// policies returns DeletePolicies with every relation set to policy.
+func policies(policy string) DeletePolicies {
+	return DeletePolicies{policy, policy, policy, policy, policy, policy, policy}
+}
+
+func TestPlanDelete(t *testing.T) {
+	mixed := policies(policyDeactivate)
+	mixed.CategorySubCategories = policyCascade
+	mixed.CategoryBrands, mixed.SubCategoryBrands = policyRestrict, policyRestrict
+
+	subtrees := policies(policyRestrict)
+	subtrees.CategorySubCategories = policyCascade
+
+	overlap := policies(policyCascade)
+	overlap.CategoryProducts = policyDeactivate
+
+	tests := []struct {
+		name        string
+		policies    DeletePolicies
+		ref         docRef
+		deleted     []string
+		deactivated []string
+		blocked     []string // dependent>referenced
+	}{
+		{
+			name:     "nothing depends on it",
+			policies: policies(policyRestrict),
+			ref:      docRef{"product", "PRD1"},
+			deleted:  []string{"product:PRD1"},
+		},
+		{
+			name:     "restricted by every dependent",
+			policies: policies(policyRestrict),
+			ref:      docRef{"category", "CAT1"},
+			deleted:  []string{"category:CAT1"},
+			blocked:  []string{"subcategory:SUB1>category:CAT1", "subcategory:SUB2>category:CAT1", "product:PRD1>category:CAT1", "product:PRD3>category:CAT1"},
+		},
+		{
+			name:     "restriction below a cascade",
+			policies: subtrees,
+			ref:      docRef{"subcategory", "SUB1"},
+			deleted:  []string{"subcategory:SUB1", "subcategory:SUB4"},
+			blocked:  []string{"assignment:BRD1:SUB1>subcategory:SUB1", "assignment:BRD2:SUB1>subcategory:SUB1", "product:PRD1>subcategory:SUB1"},
+		},
+		{
+			name:     "cascade through the tree",
+			policies: policies(policyCascade),
+			ref:      docRef{"subcategory", "SUB1"},
+			deleted:  []string{"subcategory:SUB1", "subcategory:SUB4", "assignment:BRD1:SUB1", "assignment:BRD2:SUB1", "product:PRD1"},
+		},
+		{
+			name:        "brand assignments go with the brand",
+			policies:    policies(policyDeactivate),
+			ref:         docRef{"brand", "BRD2"},
+			deleted:     []string{"brand:BRD2", "assignment:BRD2:SUB1", "assignment:BRD2:SUB3"},
+			deactivated: []string{"product:PRD2", "product:PRD3"},
+		},
+		{
+			name:        "deactivated once when referenced twice",
+			policies:    mixed,
+			ref:         docRef{"category", "CAT2"},
+			deleted:     []string{"category:CAT2", "subcategory:SUB3"},
+			deactivated: []string{"product:PRD2"},
+			blocked:     []string{"assignment:BRD2:SUB3>subcategory:SUB3"},
+		},
+		{
+			name:     "deleted rather than deactivated",
+			policies: overlap,
+			ref:      docRef{"category", "CAT1"},
+			deleted:  []string{"category:CAT1", "subcategory:SUB1", "subcategory:SUB4", "assignment:BRD1:SUB1", "assignment:BRD2:SUB1", "product:PRD1", "subcategory:SUB2", "product:PRD3"},
+		},
+		{
+			name:     "varient",
+			policies: policies(policyRestrict),
+			ref:      docRef{"varient", "VAR1"},
+			deleted:  []string{"varient:VAR1"},
+			blocked:  []string{"product:PRD1>varient:VAR1", "product:PRD2>varient:VAR1", "product:PRD3>varient:VAR1"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newCatalog(t)
+			plan, err := planDelete(context.Background(), s, tt.policies, tt.ref.Entity, tt.ref.ID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := refs(plan.Deleted); !equalPaths(got, tt.deleted) {
+				t.Errorf("deleted %v, want %v", got, tt.deleted)
+			}
+			if got := refs(plan.Deactivated); !equalPaths(got, tt.deactivated) {
+				t.Errorf("deactivated %v, want %v", got, tt.deactivated)
+			}
+			var blocked []string
+			for _, b := range plan.Blocked {
+				pair := refs([]docRef{b.docRef, b.References})
+				blocked = append(blocked, pair[0]+">"+pair[1])
+			}
+			if !equalPaths(blocked, tt.blocked) {
+				t.Errorf("blocked %v, want %v", blocked, tt.blocked)
+			}
+		})
+	}
+}
+
+func TestDeletePlanApply(t *testing.T) {
+	ctx := context.Background()
+	s := newCatalog(t)
+	p := policies(policyCascade)
+	p.SubCategoryProducts = policyDeactivate
+	plan, err := planDelete(ctx, s, p, "subcategory", "SUB1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = s.Tx.Run(ctx, func(ctx context.Context) error {
+		_, err := plan.apply(ctx, s, anyVersion)
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range []string{"SUB1", "SUB4"} {
+		if _, err := s.Categories.Get(ctx, id); !errors.Is(err, ErrNotFound) {
+			t.Errorf("%s: %v, want ErrNotFound", id, err)
+		}
+	}
+	left, err := s.BrandAssignments.Find(ctx, findQuery{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(left) != 1 || left[0].Key != "BRD2:SUB3" {
+		t.Errorf("assignments left %v, want BRD2:SUB3 only", left)
+	}
+	prd, err := s.Products.Get(ctx, "PRD1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if prd.Pstatus {
+		t.Error("PRD1 is still active")
+	}
+}
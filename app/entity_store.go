@@ -0,0 +1,434 @@
+package app
+
+import (
+	"context"
+	"errors"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// collection is the storage backend behind the entity stores. Both the
+// Mongo and the in-memory backends implement it for every entity type.
+// keys returns the business keys of the documents whose fields equal the
+// filter values; dotted names such as "subprod.brandid" reach nested fields.
+// set and delete take the version the document must have, or anyVersion;
+// set adds one to the version. increment adds one to a number field
+// atomically, creating the document when there is none, and returns the
+// document as updated.
+type collection[T any] interface {
+	exists(ctx context.Context, id string) (bool, error)
+	insert(ctx context.Context, doc T) (interface{}, error)
+	get(ctx context.Context, id string) (T, error)
+	getAll(ctx context.Context) ([]T, error)
+	find(ctx context.Context, q findQuery) ([]T, error)
+	count(ctx context.Context, filter bson.M) (int64, error)
+	facets(ctx context.Context, specs []facetSpec) (map[string][]facetCount, error)
+	keys(ctx context.Context, filter bson.M) ([]string, error)
+	set(ctx context.Context, id string, version int64, fields bson.M) (T, error)
+	increment(ctx context.Context, id, field string) (T, error)
+	delete(ctx context.Context, id string, version int64) (int64, error)
+}
+
+// Product
+
+type productStore struct{ collection[product] }
+
+func (s productStore) Exists(ctx context.Context, pid string) (bool, error) {
+	return s.collection.exists(ctx, pid)
+}
+
+func (s productStore) Insert(ctx context.Context, prod product) (interface{}, error) {
+	prod.Version = 1
+	prod.CreatedAt, prod.CreatedBy = stamped(ctx)
+	prod.UpdatedAt, prod.UpdatedBy = prod.CreatedAt, prod.CreatedBy
+	return s.collection.insert(ctx, prod)
+}
+
+func (s productStore) Get(ctx context.Context, pid string) (product, error) {
+	return s.collection.get(ctx, pid)
+}
+
+func (s productStore) GetAll(ctx context.Context) ([]product, error) {
+	return s.collection.getAll(ctx)
+}
+
+func (s productStore) Find(ctx context.Context, q findQuery) ([]product, error) {
+	return s.collection.find(ctx, q)
+}
+
+func (s productStore) Count(ctx context.Context, filter bson.M) (int64, error) {
+	return s.collection.count(ctx, filter)
+}
+
+func (s productStore) Facets(ctx context.Context, specs []facetSpec) (map[string][]facetCount, error) {
+	return s.collection.facets(ctx, specs)
+}
+
+func (s productStore) Referencing(ctx context.Context, field, id string) ([]string, error) {
+	return s.collection.keys(ctx, bson.M{field: id})
+}
+
+func (s productStore) Update(ctx context.Context, body productUpdate, version int64) (product, error) {
+	return s.collection.set(ctx, body.PId, version, modified(ctx, bson.M{"pname": body.Pname, "pdesc": body.Pdesc, "pqty": body.Pqty, "pmrp": body.Pmrp, "pprice": body.Pprice, "pmodifiedby": body.Pmodifiedby, "subprod": body.SubProd}))
+}
+
+func (s productStore) UpdateStatus(ctx context.Context, pid string, status bool, version int64) (product, error) {
+	return s.collection.set(ctx, pid, version, modified(ctx, bson.M{"pstatus": status}))
+}
+
+func (s productStore) Restore(ctx context.Context, p product, version int64) (product, error) {
+	return s.collection.set(ctx, p.PId, version, modified(ctx, bson.M{"pname": p.Pname, "pdesc": p.Pdesc, "pqty": p.Pqty, "pmrp": p.Pmrp, "pprice": p.Pprice, "pmodifiedby": p.Pmodifiedby, "pstatus": p.Pstatus, "subprod": p.SubProd}))
+}
+
+func (s productStore) Delete(ctx context.Context, pid string, version int64) (int64, error) {
+	return s.collection.delete(ctx, pid, version)
+}
+
+// Category
+
+type categoryStore struct{ collection[category] }
+
+func (s categoryStore) Exists(ctx context.Context, cid string) (bool, error) {
+	return s.collection.exists(ctx, cid)
+}
+
+func (s categoryStore) Insert(ctx context.Context, cat category) (interface{}, error) {
+	cat.Version = 1
+	cat.CreatedAt, cat.CreatedBy = stamped(ctx)
+	cat.UpdatedAt, cat.UpdatedBy = cat.CreatedAt, cat.CreatedBy
+	return s.collection.insert(ctx, cat)
+}
+
+func (s categoryStore) Get(ctx context.Context, cid string) (category, error) {
+	return s.collection.get(ctx, cid)
+}
+
+func (s categoryStore) GetAll(ctx context.Context) ([]category, error) {
+	return s.collection.getAll(ctx)
+}
+
+func (s categoryStore) Find(ctx context.Context, q findQuery) ([]category, error) {
+	return s.collection.find(ctx, q)
+}
+
+func (s categoryStore) Count(ctx context.Context, filter bson.M) (int64, error) {
+	return s.collection.count(ctx, filter)
+}
+
+func (s categoryStore) Referencing(ctx context.Context, field, id string) ([]string, error) {
+	return s.collection.keys(ctx, bson.M{field: id})
+}
+
+func (s categoryStore) Update(ctx context.Context, body categoryUpdate, version int64) (category, error) {
+	return s.collection.set(ctx, body.CId, version, modified(ctx, bson.M{"cname": body.Cname, "cdesc": body.Cdesc, "cmodifiedby": body.Cmodifiedby}))
+}
+
+func (s categoryStore) UpdateStatus(ctx context.Context, cid string, status bool, version int64) (category, error) {
+	return s.collection.set(ctx, cid, version, modified(ctx, bson.M{"cstatus": status}))
+}
+
+// Restore leaves the parent and path as they are: those change through Move.
+func (s categoryStore) Restore(ctx context.Context, c category, version int64) (category, error) {
+	return s.collection.set(ctx, c.CId, version, modified(ctx, bson.M{"cname": c.Cname, "cdesc": c.Cdesc, "cmodifiedby": c.Cmodifiedby, "cstatus": c.Cstatus}))
+}
+
+// Move sets the parent and path of a category; moveCategory keeps the subtree consistent.
+func (s categoryStore) Move(ctx context.Context, cid, parent string, path []string) (category, error) {
+	return s.collection.set(ctx, cid, anyVersion, modified(ctx, bson.M{"cparent": parent, "cpath": path}))
+}
+
+func (s categoryStore) Delete(ctx context.Context, cid string, version int64) (int64, error) {
+	return s.collection.delete(ctx, cid, version)
+}
+
+// SubCategory
+
+// subCategoryStore serves subcategories as a view of the category
+// collection: a subcategory is a category with a parent, which it reports as
+// its cid. Filters and sort fields are translated to the category names.
+type subCategoryStore struct{ collection[category] }
+
+// subCategoryFields maps subcategory field names to the category fields holding them.
+var subCategoryFields = map[string]string{
+	"scid":         "cid",
+	"cid":          "cparent",
+	"scname":       "cname",
+	"scdesc":       "cdesc",
+	"sccreatedby":  "ccreatedby",
+	"scmodifiedby": "cmodifiedby",
+	"scstatus":     "cstatus",
+}
+
+// nodeFilter translates a filter on subcategory fields and keeps it to categories with a parent.
+func nodeFilter(filter bson.M) bson.M {
+	f := bson.M{"cparent": bson.M{"$gt": ""}}
+	for k, v := range filter {
+		if field, ok := subCategoryFields[k]; ok {
+			k = field
+		}
+		f[k] = v
+	}
+	return f
+}
+
+// subCategoryError reports a clash on the category name index in the terms of the subcategory view.
+func subCategoryError(err error) error {
+	var dup duplicateKeyError
+	if errors.As(err, &dup) && dup.index.name == categoryNames.name {
+		dup.index.field, dup.index.message = "scname", "a subcategory with this name already exists in the category"
+		return dup
+	}
+	return err
+}
+
+func asSubCategory(c category) subcategory {
+	return subcategory{ScId: c.CId, CId: c.CParent, Scname: c.Cname, Scdesc: c.Cdesc, Sccreatedby: c.Ccreatedby, Scmodifiedby: c.Cmodifiedby, Scstatus: c.Cstatus, Version: c.Version, CreatedAt: c.CreatedAt, UpdatedAt: c.UpdatedAt, CreatedBy: c.CreatedBy, UpdatedBy: c.UpdatedBy}
+}
+
+func (s subCategoryStore) Exists(ctx context.Context, scid string) (bool, error) {
+	n, err := s.collection.count(ctx, nodeFilter(bson.M{"scid": scid}))
+	return n > 0, err
+}
+
+// Insert adds the subcategory under its cid; ErrNotFound when there is no such category.
+func (s subCategoryStore) Insert(ctx context.Context, subc subcategory) (interface{}, error) {
+	parent, err := s.collection.get(ctx, subc.CId)
+	if err != nil {
+		return nil, err
+	}
+	at, by := stamped(ctx)
+	id, err := s.collection.insert(ctx, category{CId: subc.ScId, CParent: subc.CId, CPath: childPath(parent), Cname: subc.Scname, Cdesc: subc.Scdesc, Ccreatedby: subc.Sccreatedby, Cmodifiedby: subc.Scmodifiedby, Cstatus: subc.Scstatus, Version: 1, CreatedAt: at, UpdatedAt: at, CreatedBy: by, UpdatedBy: by})
+	return id, subCategoryError(err)
+}
+
+func (s subCategoryStore) Get(ctx context.Context, scid string) (subcategory, error) {
+	c, err := s.collection.get(ctx, scid)
+	if err == nil && c.CParent == "" {
+		err = ErrNotFound // a top-level category
+	}
+	return asSubCategory(c), err
+}
+
+func (s subCategoryStore) GetAll(ctx context.Context) ([]subcategory, error) {
+	return s.Find(ctx, findQuery{})
+}
+
+func (s subCategoryStore) Find(ctx context.Context, q findQuery) ([]subcategory, error) {
+	q.Filter = nodeFilter(q.Filter)
+	if field, ok := subCategoryFields[q.Sort]; ok {
+		q.Sort = field
+	}
+	nodes, err := s.collection.find(ctx, q)
+	results := make([]subcategory, 0, len(nodes))
+	for _, c := range nodes {
+		results = append(results, asSubCategory(c))
+	}
+	return results, err
+}
+
+func (s subCategoryStore) Count(ctx context.Context, filter bson.M) (int64, error) {
+	return s.collection.count(ctx, nodeFilter(filter))
+}
+
+func (s subCategoryStore) Referencing(ctx context.Context, field, id string) ([]string, error) {
+	return s.collection.keys(ctx, nodeFilter(bson.M{field: id}))
+}
+
+// Update changes the name and description; moving to another category is moveCategory's job.
+func (s subCategoryStore) Update(ctx context.Context, body subcategoryUpdate, version int64) (subcategory, error) {
+	if _, err := s.Get(ctx, body.ScId); err != nil {
+		return subcategory{}, err
+	}
+	c, err := s.collection.set(ctx, body.ScId, version, modified(ctx, bson.M{"cname": body.Scname, "cdesc": body.Scdesc, "cmodifiedby": body.Scmodifiedby}))
+	return asSubCategory(c), subCategoryError(err)
+}
+
+func (s subCategoryStore) UpdateStatus(ctx context.Context, scid string, status bool, version int64) (subcategory, error) {
+	if _, err := s.Get(ctx, scid); err != nil {
+		return subcategory{}, err
+	}
+	c, err := s.collection.set(ctx, scid, version, modified(ctx, bson.M{"cstatus": status}))
+	return asSubCategory(c), err
+}
+
+func (s subCategoryStore) Delete(ctx context.Context, scid string, version int64) (int64, error) {
+	if _, err := s.Get(ctx, scid); errors.Is(err, ErrNotFound) {
+		return 0, nil
+	} else if err != nil {
+		return 0, err
+	}
+	return s.collection.delete(ctx, scid, version)
+}
+
+// Brand
+
+type brandStore struct{ collection[brand] }
+
+func (s brandStore) Exists(ctx context.Context, bid string) (bool, error) {
+	return s.collection.exists(ctx, bid)
+}
+
+func (s brandStore) Insert(ctx context.Context, b brand) (interface{}, error) {
+	b.Version = 1
+	b.CreatedAt, b.CreatedBy = stamped(ctx)
+	b.UpdatedAt, b.UpdatedBy = b.CreatedAt, b.CreatedBy
+	return s.collection.insert(ctx, b)
+}
+
+func (s brandStore) Get(ctx context.Context, bid string) (brand, error) {
+	return s.collection.get(ctx, bid)
+}
+
+func (s brandStore) GetAll(ctx context.Context) ([]brand, error) {
+	return s.collection.getAll(ctx)
+}
+
+func (s brandStore) Find(ctx context.Context, q findQuery) ([]brand, error) {
+	return s.collection.find(ctx, q)
+}
+
+func (s brandStore) Count(ctx context.Context, filter bson.M) (int64, error) {
+	return s.collection.count(ctx, filter)
+}
+
+func (s brandStore) Referencing(ctx context.Context, field, id string) ([]string, error) {
+	return s.collection.keys(ctx, bson.M{field: id})
+}
+
+func (s brandStore) Update(ctx context.Context, body brandUpdate, version int64) (brand, error) {
+	return s.collection.set(ctx, body.BId, version, modified(ctx, bson.M{"bname": body.Bname, "bdesc": body.Bdesc, "bmodifiedby": body.Bmodifiedby}))
+}
+
+func (s brandStore) UpdateStatus(ctx context.Context, bid string, status bool, version int64) (brand, error) {
+	return s.collection.set(ctx, bid, version, modified(ctx, bson.M{"bstatus": status}))
+}
+
+func (s brandStore) Restore(ctx context.Context, b brand, version int64) (brand, error) {
+	return s.collection.set(ctx, b.BId, version, modified(ctx, bson.M{"bname": b.Bname, "bdesc": b.Bdesc, "bmodifiedby": b.Bmodifiedby, "bstatus": b.Bstatus}))
+}
+
+func (s brandStore) Delete(ctx context.Context, bid string, version int64) (int64, error) {
+	return s.collection.delete(ctx, bid, version)
+}
+
+// Brand assignments
+
+type brandAssignmentStore struct{ collection[brandAssignment] }
+
+func (s brandAssignmentStore) Exists(ctx context.Context, key string) (bool, error) {
+	return s.collection.exists(ctx, key)
+}
+
+func (s brandAssignmentStore) Insert(ctx context.Context, a brandAssignment) (interface{}, error) {
+	return s.collection.insert(ctx, a)
+}
+
+func (s brandAssignmentStore) Find(ctx context.Context, q findQuery) ([]brandAssignment, error) {
+	return s.collection.find(ctx, q)
+}
+
+func (s brandAssignmentStore) Referencing(ctx context.Context, field, id string) ([]string, error) {
+	return s.collection.keys(ctx, bson.M{field: id})
+}
+
+func (s brandAssignmentStore) Delete(ctx context.Context, key string, version int64) (int64, error) {
+	return s.collection.delete(ctx, key, version)
+}
+
+// Varient
+
+type varientStore struct{ collection[varient] }
+
+func (s varientStore) Exists(ctx context.Context, vid string) (bool, error) {
+	return s.collection.exists(ctx, vid)
+}
+
+func (s varientStore) Insert(ctx context.Context, v varient) (interface{}, error) {
+	v.Version = 1
+	v.CreatedAt, v.CreatedBy = stamped(ctx)
+	v.UpdatedAt, v.UpdatedBy = v.CreatedAt, v.CreatedBy
+	return s.collection.insert(ctx, v)
+}
+
+func (s varientStore) Get(ctx context.Context, vid string) (varient, error) {
+	return s.collection.get(ctx, vid)
+}
+
+func (s varientStore) GetAll(ctx context.Context) ([]varient, error) {
+	return s.collection.getAll(ctx)
+}
+
+func (s varientStore) Find(ctx context.Context, q findQuery) ([]varient, error) {
+	return s.collection.find(ctx, q)
+}
+
+func (s varientStore) Count(ctx context.Context, filter bson.M) (int64, error) {
+	return s.collection.count(ctx, filter)
+}
+
+func (s varientStore) Referencing(ctx context.Context, field, id string) ([]string, error) {
+	return s.collection.keys(ctx, bson.M{field: id})
+}
+
+func (s varientStore) Update(ctx context.Context, body varientUpdate, version int64) (varient, error) {
+	return s.collection.set(ctx, body.VId, version, modified(ctx, bson.M{"vname": body.Vname, "vdesc": body.Vdesc, "vmodifiedby": body.Vmodifiedby}))
+}
+
+func (s varientStore) UpdateStatus(ctx context.Context, vid string, status bool, version int64) (varient, error) {
+	return s.collection.set(ctx, vid, version, modified(ctx, bson.M{"vstatus": status}))
+}
+
+func (s varientStore) Restore(ctx context.Context, v varient, version int64) (varient, error) {
+	return s.collection.set(ctx, v.VId, version, modified(ctx, bson.M{"vname": v.Vname, "vdesc": v.Vdesc, "vmodifiedby": v.Vmodifiedby, "vstatus": v.Vstatus}))
+}
+
+func (s varientStore) Delete(ctx context.Context, vid string, version int64) (int64, error) {
+	return s.collection.delete(ctx, vid, version)
+}
+
+// Status records
+
+type statusRecordStore struct{ collection[statusRecord] }
+
+func (s statusRecordStore) Get(ctx context.Context, key string) (statusRecord, error) {
+	return s.collection.get(ctx, key)
+}
+
+// Save inserts the record, or replaces the saved statuses of an existing one.
+func (s statusRecordStore) Save(ctx context.Context, rec statusRecord) error {
+	_, err := s.collection.set(ctx, rec.Key, anyVersion, bson.M{"previous": rec.Previous, "at": rec.At})
+	if errors.Is(err, ErrNotFound) {
+		_, err = s.collection.insert(ctx, rec)
+	}
+	return err
+}
+
+func (s statusRecordStore) Delete(ctx context.Context, key string) (int64, error) {
+	return s.collection.delete(ctx, key, anyVersion)
+}
+
+// Audit
+
+type auditStore struct{ collection[auditEntry] }
+
+func (s auditStore) Insert(ctx context.Context, e auditEntry) error {
+	_, err := s.collection.insert(ctx, e)
+	return err
+}
+
+func (s auditStore) Find(ctx context.Context, q findQuery) ([]auditEntry, error) {
+	return s.collection.find(ctx, q)
+}
+
+func (s auditStore) Count(ctx context.Context, filter bson.M) (int64, error) {
+	return s.collection.count(ctx, filter)
+}
+
+// Counter
+
+type counterStore struct{ collection[counter] }
+
+func (s counterStore) Next(ctx context.Context, name string) (int64, error) {
+	c, err := s.collection.increment(ctx, name, "seq")
+	return c.Seq, err
+}
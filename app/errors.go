@@ -0,0 +1,126 @@
+package app
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+)
+
+// error codes returned in the "code" field of an error response
+const (
+	codeBadRequest       = "bad_request"
+	codeInvalidID        = "invalid_id"
+	codeNotFound         = "not_found"
+	codeMethodNotAllowed = "method_not_allowed"
+	codeDuplicate        = "duplicate"
+	codeHasDependents    = "has_dependents"
+	codeCycle            = "cycle"
+	codePrecondition     = "precondition_failed"
+	codeValidation       = "validation_failed"
+	codeInvalidReference = "invalid_reference"
+	codeInternal         = "internal_error"
+)
+
+// errorBody is the envelope of every error response:
+//
+//	{"error": {"code": "...", "message": "...", "details": [...], "requestId": "..."}}
+type errorBody struct {
+	Error apiError `json:"error"`
+}
+
+type apiError struct {
+	Code      string       `json:"code"`
+	Message   string       `json:"message"`
+	Details   []fieldError `json:"details,omitempty"`
+	RequestID string       `json:"requestId,omitempty"`
+}
+
+// fieldError describes what is wrong with one field of the request.
+type fieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+type requestIDKey struct{}
+
+// RequestID tags every request with an ID, taken from the X-Request-ID header
+// when the client sends one, and echoes it back in the response header.
+func RequestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get("X-Request-ID")
+		if id == "" {
+			b := make([]byte, 8)
+			rand.Read(b)
+			id = hex.EncodeToString(b)
+		}
+		w.Header().Set("X-Request-ID", id)
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), requestIDKey{}, id)))
+	})
+}
+
+func requestIDFrom(r *http.Request) string {
+	return requestIDOf(r.Context())
+}
+
+// requestIDOf returns the ID of the request ctx belongs to, empty outside one.
+func requestIDOf(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// writeJSON sends v with the given status code.
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json") // for adding Content-type
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// writeError sends an error envelope with the given status code.
+func writeError(w http.ResponseWriter, r *http.Request, status int, code, message string, details ...fieldError) {
+	writeJSON(w, status, errorBody{apiError{Code: code, Message: message, Details: details, RequestID: requestIDFrom(r)}})
+}
+
+// requestError is an error with its own response, for handlers to return
+// from a unit of work when a check inside it fails.
+type requestError struct {
+	status  int
+	code    string
+	message string
+	details []fieldError
+}
+
+func (e requestError) Error() string { return e.message }
+
+// storeError maps an error returned by a store, or a requestError, to its response.
+func storeError(w http.ResponseWriter, r *http.Request, err error) {
+	var dup duplicateKeyError
+	var req requestError
+	switch {
+	case errors.As(err, &req):
+		writeError(w, r, req.status, req.code, req.message, req.details...)
+	case errors.Is(err, ErrVersionMismatch):
+		writeError(w, r, http.StatusPreconditionFailed, codePrecondition, "Document has changed since it was read")
+	case errors.Is(err, ErrNotFound):
+		writeError(w, r, http.StatusNotFound, codeNotFound, "No data found!")
+	case errors.As(err, &dup):
+		writeError(w, r, http.StatusConflict, codeDuplicate, "Duplicate document!", fieldError{Field: dup.index.field, Message: dup.index.message})
+	case errors.Is(err, ErrDuplicate):
+		writeError(w, r, http.StatusConflict, codeDuplicate, "Duplicate document!")
+	default:
+		logErrorf("request %s: %v", requestIDFrom(r), err)
+		writeError(w, r, http.StatusInternalServerError, codeInternal, "Internal server error")
+	}
+}
+
+// NotFound answers requests for unknown routes.
+func NotFound(w http.ResponseWriter, r *http.Request) {
+	writeError(w, r, http.StatusNotFound, codeNotFound, "No such endpoint")
+}
+
+// MethodNotAllowed answers requests using the wrong method for a route.
+func MethodNotAllowed(w http.ResponseWriter, r *http.Request) {
+	writeError(w, r, http.StatusMethodNotAllowed, codeMethodNotAllowed, "Method not allowed")
+}
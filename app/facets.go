@@ -0,0 +1,194 @@
+package app
+
+import (
+	"context"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// defaultPriceBuckets are the lower bounds of the price facet; the last bucket is open-ended.
+var defaultPriceBuckets = []float64{0, 100, 500, 1000, 5000}
+
+// facetSpec asks for the number of documents matching Filter per value of
+// Field, or per range of it when Bounds (ascending lower bounds) is set.
+type facetSpec struct {
+	Name   string
+	Field  string
+	Filter bson.M
+	Bounds []float64
+}
+
+// facetCount is the number of documents with one value of a facet field; for
+// a ranged facet Value is the lower bound of the range.
+type facetCount struct {
+	Value interface{} `json:"value"`
+	Count int64       `json:"count"`
+}
+
+// bucketCount is one range of a ranged facet. Max is left out on the last one.
+type bucketCount struct {
+	Min   float64  `json:"min"`
+	Max   *float64 `json:"max,omitempty"`
+	Count int64    `json:"count"`
+}
+
+// productFacets is returned with GetAllProduct when facets=true.
+type productFacets struct {
+	Category    []facetCount  `json:"category"`
+	SubCategory []facetCount  `json:"subcategory"`
+	Brand       []facetCount  `json:"brand"`
+	Varient     []facetCount  `json:"varient"`
+	Status      []facetCount  `json:"status"`
+	Price       []bucketCount `json:"price"`
+}
+
+// facetFunc computes the facets of a listing from its filter.
+type facetFunc func(ctx context.Context, filter bson.M) (interface{}, error)
+
+// parseProductFacets reads facets and priceBuckets from the query string. It
+// returns nil when no facets were asked for; on a bad parameter it answers
+// 400 and returns false.
+func (h *Handler) parseProductFacets(w http.ResponseWriter, r *http.Request) (facetFunc, bool) {
+	params := r.URL.Query()
+	if params.Get("facets") == "" {
+		return nil, true
+	}
+	want, err := strconv.ParseBool(params.Get("facets"))
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, codeBadRequest, "Invalid query parameters", fieldError{Field: "facets", Message: "facets must be true or false"})
+		return nil, false
+	}
+	if !want {
+		return nil, true
+	}
+
+	bounds := defaultPriceBuckets
+	if v := params.Get("priceBuckets"); v != "" {
+		bounds = nil
+		for _, part := range strings.Split(v, ",") {
+			n, err := strconv.ParseFloat(part, 64)
+			if err != nil || len(bounds) > 0 && n <= bounds[len(bounds)-1] {
+				writeError(w, r, http.StatusBadRequest, codeBadRequest, "Invalid query parameters", fieldError{Field: "priceBuckets", Message: "priceBuckets must be ascending numbers, e.g. 0,100,500"})
+				return nil, false
+			}
+			bounds = append(bounds, n)
+		}
+	}
+
+	return func(ctx context.Context, filter bson.M) (interface{}, error) {
+		// each facet ignores its own filter, so choosing one brand still counts the others
+		without := func(field string) bson.M {
+			f := bson.M{}
+			for k, v := range filter {
+				if k != field {
+					f[k] = v
+				}
+			}
+			return f
+		}
+		specs := []facetSpec{
+			{Name: "category", Field: "subprod.categoryid"},
+			{Name: "subcategory", Field: "subprod.subcategoryid"},
+			{Name: "brand", Field: "subprod.brandid"},
+			{Name: "varient", Field: "subprod.varientid"},
+			{Name: "status", Field: "pstatus"},
+			{Name: "price", Field: "pprice", Bounds: bounds},
+		}
+		for i := range specs {
+			specs[i].Filter = without(specs[i].Field)
+		}
+		counts, err := h.store.Products.Facets(ctx, specs)
+		if err != nil {
+			return nil, err
+		}
+		result := productFacets{
+			Category:    nonNil(counts["category"]),
+			SubCategory: nonNil(counts["subcategory"]),
+			Brand:       nonNil(counts["brand"]),
+			Varient:     nonNil(counts["varient"]),
+			Status:      nonNil(counts["status"]),
+			Price:       []bucketCount{},
+		}
+		byBound := map[float64]int64{}
+		for _, c := range counts["price"] {
+			if min, ok := c.Value.(float64); ok {
+				byBound[min] = c.Count
+			}
+		}
+		for i, min := range bounds {
+			b := bucketCount{Min: min, Count: byBound[min]}
+			if i+1 < len(bounds) {
+				max := bounds[i+1]
+				b.Max = &max
+			}
+			result.Price = append(result.Price, b)
+		}
+		return result, nil
+	}, true
+}
+
+func nonNil(counts []facetCount) []facetCount {
+	if counts == nil {
+		return []facetCount{}
+	}
+	return counts
+}
+
+// facetCounts evaluates facet specs over documents in memory, the way the
+// Mongo $facet stage would: values in ascending order, ranges by lower bound.
+func facetCounts(docs []bson.M, specs []facetSpec) map[string][]facetCount {
+	result := map[string][]facetCount{}
+	for _, spec := range specs {
+		var counts []facetCount
+		index := map[interface{}]int{} // value -> position in counts
+		add := func(v interface{}) {
+			key := v
+			if n, ok := sortableNumber(v); ok {
+				key = n // 90 and 90.0 are one value
+			}
+			if i, ok := index[key]; ok {
+				counts[i].Count++
+				return
+			}
+			index[key] = len(counts)
+			counts = append(counts, facetCount{v, 1})
+		}
+		for _, doc := range docs {
+			if !matches(doc, spec.Filter) {
+				continue
+			}
+			v, _ := lookup(doc, spec.Field)
+			if spec.Bounds == nil {
+				add(v)
+				continue
+			}
+			// the last bound not above the value; values below every bound are left out
+			n, numeric := sortableNumber(v)
+			if !numeric {
+				continue
+			}
+			for i := len(spec.Bounds) - 1; i >= 0; i-- {
+				if n >= spec.Bounds[i] {
+					add(spec.Bounds[i])
+					break
+				}
+			}
+		}
+		sort.Slice(counts, func(i, j int) bool { return compareValues(counts[i].Value, counts[j].Value) < 0 })
+		result[spec.Name] = counts
+	}
+	return result
+}
+
+// sortableNumber reports whether v is a BSON number.
+func sortableNumber(v interface{}) (float64, bool) {
+	rank, n := sortable(v)
+	if rank != 1 {
+		return 0, false
+	}
+	return n.(float64), true
+}
@@ -0,0 +1,69 @@
+package app
+
+import (
+	"context"
+	"testing"
+)
+
+// newCatalog returns memory stores holding a small catalog:
+//
+//	CAT1 ── SUB1 ── SUB4      CAT2 ── SUB3
+//	     └─ SUB2
+//
+// BRD1 is assigned to SUB1, BRD2 to SUB1 and SUB3. PRD1 is sold in
+// CAT1/SUB1 by BRD1, PRD2 in CAT2/SUB3 by BRD2 and PRD3 in CAT1/SUB2 by
+// BRD2, all as VAR1. Every document is active.
+func newCatalog(t *testing.T) Stores {
+	t.Helper()
+	ctx := context.Background()
+	s := NewMemoryStores()
+	must := func(_ interface{}, err error) {
+		t.Helper()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, c := range []category{
+		{CId: "CAT1", CPath: []string{}, Cname: "Phones", Cdesc: "all phones", Ccreatedby: "tester", Cmodifiedby: "tester", Cstatus: true},
+		{CId: "CAT2", CPath: []string{}, Cname: "Tablets", Cdesc: "all tablets", Ccreatedby: "tester", Cmodifiedby: "tester", Cstatus: true},
+	} {
+		must(s.Categories.Insert(ctx, c))
+	}
+	for _, sc := range []subcategory{
+		{ScId: "SUB1", CId: "CAT1", Scname: "Smart", Scdesc: "smart phones", Sccreatedby: "tester", Scmodifiedby: "tester", Scstatus: true},
+		{ScId: "SUB2", CId: "CAT1", Scname: "Basic", Scdesc: "basic phones", Sccreatedby: "tester", Scmodifiedby: "tester", Scstatus: true},
+		{ScId: "SUB3", CId: "CAT2", Scname: "Large", Scdesc: "large tablets", Sccreatedby: "tester", Scmodifiedby: "tester", Scstatus: true},
+		{ScId: "SUB4", CId: "SUB1", Scname: "Foldable", Scdesc: "foldable phones", Sccreatedby: "tester", Scmodifiedby: "tester", Scstatus: true},
+	} {
+		must(s.SubCategories.Insert(ctx, sc))
+	}
+	for _, b := range []brand{
+		{BId: "BRD1", Bname: "Acme", Bdesc: "acme brand", Bcreatedby: "tester", Bmodifiedby: "tester", Bstatus: true},
+		{BId: "BRD2", Bname: "Bolt", Bdesc: "bolt brand", Bcreatedby: "tester", Bmodifiedby: "tester", Bstatus: true},
+	} {
+		must(s.Brands.Insert(ctx, b))
+	}
+	for _, a := range [][2]string{{"BRD1", "SUB1"}, {"BRD2", "SUB1"}, {"BRD2", "SUB3"}} {
+		must(s.BrandAssignments.Insert(ctx, brandAssignment{assignmentKey(a[0], a[1]), a[0], a[1]}))
+	}
+	must(s.Varients.Insert(ctx, varient{VId: "VAR1", Vname: "Black", Vdesc: "black finish", Vcreatedby: "tester", Vmodifiedby: "tester", Vstatus: true}))
+	for _, p := range []product{
+		{PId: "PRD1", Pname: "Phone X", SubProd: subprod{"CAT1", "SUB1", "BRD1", "VAR1"}},
+		{PId: "PRD2", Pname: "Tab Y", SubProd: subprod{"CAT2", "SUB3", "BRD2", "VAR1"}},
+		{PId: "PRD3", Pname: "Phone Z", SubProd: subprod{"CAT1", "SUB2", "BRD2", "VAR1"}},
+	} {
+		p.Pdesc, p.Pqty, p.Pmrp, p.Pprice = "a product", 1, 10, 9
+		p.Pcreatedby, p.Pmodifiedby, p.Pstatus = "tester", "tester", true
+		must(s.Products.Insert(ctx, p))
+	}
+	return s
+}
+
+// refs formats document references as "entity:id", for comparing with want lists.
+func refs(list []docRef) []string {
+	out := make([]string, len(list))
+	for i, r := range list {
+		out[i] = r.Entity + ":" + r.ID
+	}
+	return out
+}
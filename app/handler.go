@@ -0,0 +1,13 @@
+package app
+
+// Handler serves the catalog API. Every handler reads and writes through the
+// stores of the App it was built with, so the HTTP code does not depend on MongoDB.
+type Handler struct {
+	app   *App
+	store Stores
+}
+
+// NewHandler returns a Handler backed by the given, already connected, App.
+func NewHandler(a *App) *Handler {
+	return &Handler{app: a, store: a.Stores()}
+}
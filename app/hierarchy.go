@@ -0,0 +1,77 @@
+package app
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+var (
+	// errNoTarget is returned when a category is moved under, or merged into, one that does not exist.
+	errNoTarget = errors.New("target category does not exist")
+	// errCycle is returned when a category would be placed inside its own subtree.
+	errCycle = errors.New("category cannot be placed inside its own subtree")
+)
+
+// childPath returns the path of a category placed directly under parent.
+func childPath(parent category) []string {
+	return append(append([]string{}, parent.CPath...), parent.CId)
+}
+
+func contains(ids []string, id string) bool {
+	for _, v := range ids {
+		if v == id {
+			return true
+		}
+	}
+	return false
+}
+
+// moveError answers a failed move or merge plan; field names the target in the request body.
+func moveError(w http.ResponseWriter, r *http.Request, err error, field string) {
+	switch {
+	case errors.Is(err, errNoTarget):
+		writeError(w, r, http.StatusUnprocessableEntity, codeInvalidReference, "Invalid Category Id", fieldError{Field: field, Message: "no such document"})
+	case errors.Is(err, errCycle):
+		writeError(w, r, http.StatusConflict, codeCycle, "Move would create a cycle", fieldError{Field: field, Message: err.Error()})
+	default:
+		storeError(w, r, err)
+	}
+}
+
+// breadcrumb is one category on the way from the top of the hierarchy.
+type breadcrumb struct {
+	CId   string `json:"cid"`
+	Cname string `json:"cname"`
+}
+
+// Get Category Breadcrumbs
+
+func (h *Handler) GetCategoryBreadcrumbs(w http.ResponseWriter, r *http.Request) {
+	params := mux.Vars(r)["id"] //get Parameter value as string
+	if !validID(w, r, params, "Category") {
+		return
+	}
+	node, err := h.store.Categories.Get(r.Context(), params)
+	if err != nil {
+		storeError(w, r, err)
+		return
+	}
+	ancestors, err := h.store.Categories.Find(r.Context(), findQuery{Filter: bson.M{"cid": bson.M{"$in": append([]string{}, node.CPath...)}}})
+	if err != nil {
+		storeError(w, r, err)
+		return
+	}
+	names := map[string]string{}
+	for _, a := range ancestors {
+		names[a.CId] = a.Cname
+	}
+	crumbs := make([]breadcrumb, 0, len(node.CPath)+1)
+	for _, id := range node.CPath {
+		crumbs = append(crumbs, breadcrumb{id, names[id]})
+	}
+	crumbs = append(crumbs, breadcrumb{node.CId, node.Cname})
+	writeJSON(w, http.StatusOK, map[string][]breadcrumb{"data": crumbs})
+}
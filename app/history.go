@@ -0,0 +1,56 @@
+package app
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// documentHistory lists the audit entries of one document. Categories and
+// subcategories share their IDs, and a move can turn one into the other, so
+// either kind lists the entries of both.
+type documentHistory struct {
+	log      AuditStore
+	id       string
+	entities []string
+}
+
+func (l documentHistory) scope(filter bson.M) bson.M {
+	f := bson.M{"id": l.id, "entity": bson.M{"$in": l.entities}}
+	for k, v := range filter {
+		f[k] = v
+	}
+	return f
+}
+
+func (l documentHistory) Find(ctx context.Context, q findQuery) ([]auditEntry, error) {
+	q.Filter = l.scope(q.Filter)
+	return l.log.Find(ctx, q)
+}
+
+func (l documentHistory) Count(ctx context.Context, filter bson.M) (int64, error) {
+	return l.log.Count(ctx, l.scope(filter))
+}
+
+// Get History
+
+func (h *Handler) GetHistory(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r) //get Parameter values as string
+	kind, id := vars["kind"], vars["id"]
+	if !validID(w, r, id, "document") {
+		return
+	}
+	entities := []string{kind} // the route only lets the catalog kinds through
+	if kind == "category" || kind == "subcategory" {
+		entities = []string{"category", "subcategory"}
+	}
+	serveList[auditEntry](w, r, documentHistory{h.store.Audit, id, entities}, "key", historyFilters, nil)
+}
+
+// Get Audit Log
+
+func (h *Handler) GetAuditLog(w http.ResponseWriter, r *http.Request) {
+	serveList[auditEntry](w, r, h.store.Audit, "key", auditFilters, nil)
+}
@@ -0,0 +1,195 @@
+package app
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// ID generation modes, see IDConfig.
+const (
+	idSequence = "sequence"
+	idULID     = "ulid"
+)
+
+// Every ID is alphanumeric and between these lengths; a ULID is 26 characters.
+const (
+	minIDLength = 4
+	maxIDLength = 26
+)
+
+// counter is the last sequence number issued under a name.
+type counter struct {
+	Name string `json:"name"`
+	Seq  int64  `json:"seq"`
+}
+
+// idKind describes the ID of one entity.
+type idKind struct {
+	name  string // counter name and key under ids.prefixes
+	field string // JSON field of the ID
+	label string // for messages
+}
+
+var (
+	productIDs     = idKind{"product", "pid", "Product"}
+	categoryIDs    = idKind{"category", "cid", "Category"}
+	subcategoryIDs = idKind{"subcategory", "scid", "Subcategory"}
+	brandIDs       = idKind{"brand", "bid", "Brand"}
+	varientIDs     = idKind{"varient", "vid", "Varient"}
+)
+
+func (p IDPrefixes) of(kind idKind) string {
+	switch kind {
+	case productIDs:
+		return p.Product
+	case categoryIDs:
+		return p.Category
+	case subcategoryIDs:
+		return p.SubCategory
+	case brandIDs:
+		return p.Brand
+	default:
+		return p.Varient
+	}
+}
+
+// maxIDAttempts bounds how many generated IDs are tried before giving up,
+// each one being skipped because a client already took it.
+const maxIDAttempts = 10
+
+// newID settles the ID of a document about to be created. An ID sent by the
+// client is kept, unless the config leaves IDs to the server, and must not
+// be taken yet; a missing one is generated. On failure it answers and
+// returns false.
+func (h *Handler) newID(w http.ResponseWriter, r *http.Request, kind idKind, id *string, taken func(ctx context.Context, id string) (bool, error)) bool {
+	ctx := r.Context()
+	cfg := h.app.Config().IDs
+	if *id != "" {
+		if !cfg.AllowClient {
+			writeError(w, r, http.StatusUnprocessableEntity, codeValidation, "Validation error", fieldError{Field: kind.field, Message: kind.field + " is assigned by the server"})
+			return false
+		}
+		exists, err := taken(ctx, *id)
+		if err != nil {
+			storeError(w, r, err)
+			return false
+		}
+		if exists {
+			writeError(w, r, http.StatusConflict, codeDuplicate, "Duplicate "+kind.label+"!")
+			return false
+		}
+		return true
+	}
+	for i := 0; i < maxIDAttempts; i++ {
+		generated, err := h.generateID(ctx, cfg, kind)
+		if err != nil {
+			storeError(w, r, err)
+			return false
+		}
+		exists, err := taken(ctx, generated)
+		if err != nil {
+			storeError(w, r, err)
+			return false
+		}
+		if !exists {
+			logDebugf("generated %s %s", kind.field, generated)
+			*id = generated
+			return true
+		}
+	}
+	storeError(w, r, fmt.Errorf("no free %s after %d attempts", kind.field, maxIDAttempts))
+	return false
+}
+
+func (h *Handler) generateID(ctx context.Context, cfg IDConfig, kind idKind) (string, error) {
+	if cfg.Generate == idULID {
+		return ulids.next(time.Now())
+	}
+	n, err := h.store.Counters.Next(ctx, kind.name)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%s%0*d", cfg.Prefixes.of(kind), cfg.Width, n), nil
+}
+
+// crockford is the base32 alphabet of ULIDs, without I, L, O and U.
+const crockford = "0123456789ABCDEFGHJKMNPQRSTVWXYZ"
+
+// newULID returns a ULID: 48 bits of milliseconds since the epoch and 80
+// random bits, as 26 base32 characters that sort by creation time.
+func newULID(t time.Time) (string, error) {
+	var b [16]byte
+	putULIDTime(&b, t)
+	if _, err := rand.Read(b[6:]); err != nil {
+		return "", err
+	}
+	return encodeULID(b), nil
+}
+
+// ulidSource issues ULIDs that sort in the order they were issued. Within a
+// millisecond, or when the clock goes back, the random bits of the previous
+// ULID are incremented instead of drawn again, as in the monotonic mode of
+// the ULID spec.
+type ulidSource struct {
+	mu   sync.Mutex
+	last [16]byte
+}
+
+// ulids issues the IDs generated with ids.generate: ulid.
+var ulids ulidSource
+
+func (u *ulidSource) next(t time.Time) (string, error) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	var b [16]byte
+	putULIDTime(&b, t)
+	if bytes.Compare(b[:6], u.last[:6]) > 0 {
+		if _, err := rand.Read(b[6:]); err != nil {
+			return "", err
+		}
+	} else {
+		b = u.last
+		for i := len(b) - 1; ; i-- { // add one to the random bits
+			b[i]++
+			if b[i] != 0 {
+				break
+			}
+			if i == 6 {
+				return "", errors.New("no ULID left in this millisecond")
+			}
+		}
+	}
+	u.last = b
+	return encodeULID(b), nil
+}
+
+// putULIDTime writes the milliseconds of t into the first 48 bits of b.
+func putULIDTime(b *[16]byte, t time.Time) {
+	var ms [8]byte
+	binary.BigEndian.PutUint64(ms[:], uint64(t.UnixMilli()))
+	copy(b[:6], ms[2:])
+}
+
+// encodeULID writes the 128 bits of b as 26 base32 characters.
+func encodeULID(b [16]byte) string {
+	out := make([]byte, 26)
+	for i := range out {
+		v := 0
+		for j := 0; j < 5; j++ {
+			bit := i*5 + j - 2 // the 128 bits are right-aligned in 130
+			v <<= 1
+			if bit >= 0 && b[bit/8]&(0x80>>(bit%8)) != 0 {
+				v |= 1
+			}
+		}
+		out[i] = crockford[v]
+	}
+	return string(out)
+}
@@ -0,0 +1,119 @@
+package app
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestEncodeULID(t *testing.T) {
+	var b [16]byte
+	putULIDTime(&b, time.UnixMilli(1469918176385))
+	tests := []struct {
+		name string
+		b    [16]byte
+		want string
+	}{
+		{"zero", [16]byte{}, "00000000000000000000000000"},
+		{"time of the spec example", b, "01ARYZ6S410000000000000000"},
+		{"last random bit", [16]byte{15: 1}, "00000000000000000000000001"},
+		{"every bit", [16]byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}, "7ZZZZZZZZZZZZZZZZZZZZZZZZZ"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := encodeULID(tt.b); got != tt.want {
+				t.Errorf("got %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestULIDMonotonic(t *testing.T) {
+	start := time.UnixMilli(1700000000000)
+	tests := []struct {
+		name  string
+		times []time.Time
+	}{
+		{"same millisecond", []time.Time{start, start, start, start}},
+		{"later milliseconds", []time.Time{start, start.Add(time.Millisecond), start.Add(time.Second)}},
+		{"clock going back", []time.Time{start, start.Add(-time.Second), start.Add(-time.Millisecond), start}},
+		{"within a millisecond", []time.Time{start, start.Add(time.Microsecond), start.Add(999 * time.Microsecond)}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var u ulidSource
+			prev := ""
+			for i, at := range tt.times {
+				id, err := u.next(at)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if len(id) != 26 || strings.Trim(id, crockford) != "" {
+					t.Fatalf("%q is not a ULID", id)
+				}
+				if id <= prev {
+					t.Fatalf("ID %d: %s does not sort after %s", i, id, prev)
+				}
+				prev = id
+			}
+		})
+	}
+}
+
+func TestULIDCarry(t *testing.T) {
+	at := time.UnixMilli(1700000000000)
+	u := ulidSource{}
+	putULIDTime(&u.last, at)
+	for i := 7; i < 16; i++ {
+		u.last[i] = 0xff
+	}
+	id, err := u.next(at)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := encodeULID(u.last); id != want || u.last[6] != 1 || u.last[15] != 0 {
+		t.Errorf("got %s, want the random bits carried over into %s", id, want)
+	}
+
+	for i := 6; i < 16; i++ {
+		u.last[i] = 0xff
+	}
+	used := encodeULID(u.last)
+	if _, err := u.next(at); err == nil {
+		t.Error("no error once the millisecond is used up")
+	}
+	if id, err := u.next(at.Add(time.Millisecond)); err != nil || id[:10] <= used[:10] {
+		t.Errorf("next millisecond: %s, %v", id, err)
+	}
+}
+
+func TestNewULID(t *testing.T) {
+	at := time.UnixMilli(1469918176385)
+	a, err := newULID(at)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := newULID(at.Add(time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(a, "01ARYZ6S41") || b <= a {
+		t.Errorf("%s then %s", a, b)
+	}
+}
+
+func TestAuditKeysMonotonic(t *testing.T) {
+	var k auditKeys
+	at := time.UnixMilli(1700000000000)
+	prev := ""
+	for i := 0; i < 100; i++ {
+		key, err := k.next(at)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if key <= prev {
+			t.Fatalf("key %d: %s does not sort after %s", i, key, prev)
+		}
+		prev = key
+	}
+}
@@ -0,0 +1,111 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// uniqueIndex is a set of fields no two documents of a collection may share.
+// With fold, strings are compared case-insensitively.
+type uniqueIndex struct {
+	name    string
+	fields  []string
+	fold    bool
+	field   string // reported in the 409 details
+	message string
+}
+
+// keyIndex makes the business key of a collection unique.
+func keyIndex(key string) uniqueIndex {
+	return uniqueIndex{name: key + "_unique", fields: []string{key}, field: key, message: "a document with this " + key + " already exists"}
+}
+
+// Names are unique within their parent: products within their subcategory
+// and categories within theirs. Brands and varients have no parent, as a
+// brand is shared by every subcategory it is assigned to, so their names
+// are unique across the catalog.
+var (
+	productNames  = uniqueIndex{"pname_unique", []string{"subprod.subcategoryid", "pname"}, true, "pname", "a product with this name already exists in the subcategory"}
+	categoryNames = uniqueIndex{"cname_unique", []string{"cparent", "cname"}, true, "cname", "a category with this name already exists under the same parent"}
+	brandNames    = uniqueIndex{"bname_unique", []string{"bname"}, true, "bname", "a brand with this name already exists"}
+	varientNames  = uniqueIndex{"vname_unique", []string{"vname"}, true, "vname", "a varient with this name already exists"}
+)
+
+// uniqueIndexes lists the indexes of every collection, by entity.
+var uniqueIndexes = map[string][]uniqueIndex{
+	"product":         {keyIndex("pid"), productNames},
+	"category":        {keyIndex("cid"), categoryNames},
+	"brand":           {keyIndex("bid"), brandNames},
+	"brandAssignment": {keyIndex("key")},
+	"varient":         {keyIndex("vid"), varientNames},
+	"statusRecord":    {keyIndex("key")},
+	"counter":         {keyIndex("name")},
+	"audit":           {keyIndex("key")},
+}
+
+// duplicateKeyError is returned when a write would break a unique index.
+type duplicateKeyError struct{ index uniqueIndex }
+
+func (e duplicateKeyError) Error() string {
+	return fmt.Sprintf("duplicate document: %s", e.index.name)
+}
+
+func (e duplicateKeyError) Is(target error) bool { return target == ErrDuplicate }
+
+// caseInsensitive compares strings ignoring case, for the fold indexes.
+var caseInsensitive = &options.Collation{Locale: "en", Strength: 2}
+
+// ensureUniqueIndexes creates the unique indexes of every collection; it is
+// a no-op when they exist. It fails while documents break one of them, so
+// duplicates from before the indexes have to be resolved first.
+func ensureUniqueIndexes(ctx context.Context, db *mongo.Database, names CollectionNames) error {
+	collections := map[string]string{
+		"product":         names.Product,
+		"category":        names.Category,
+		"brand":           names.Brand,
+		"brandAssignment": names.BrandAssignment,
+		"varient":         names.Varient,
+		"statusRecord":    names.StatusRecord,
+		"counter":         names.Counter,
+		"audit":           names.Audit,
+	}
+	for entity, indexes := range uniqueIndexes {
+		models := make([]mongo.IndexModel, 0, len(indexes))
+		for _, idx := range indexes {
+			keys := bson.D{}
+			for _, f := range idx.fields {
+				keys = append(keys, bson.E{Key: f, Value: 1})
+			}
+			opts := options.Index().SetUnique(true).SetName(idx.name)
+			if idx.fold {
+				opts.SetCollation(caseInsensitive)
+			}
+			models = append(models, mongo.IndexModel{Keys: keys, Options: opts})
+		}
+		if _, err := db.Collection(collections[entity]).Indexes().CreateMany(ctx, models); err != nil {
+			return fmt.Errorf("creating unique indexes on %s (remove duplicate documents first): %w", collections[entity], err)
+		}
+	}
+	return nil
+}
+
+// mongoWriteError turns a duplicate key error of the Mongo driver into a
+// duplicateKeyError naming the index, and returns other errors unchanged.
+func mongoWriteError(err error) error {
+	if !mongo.IsDuplicateKeyError(err) {
+		return err
+	}
+	for _, indexes := range uniqueIndexes {
+		for _, idx := range indexes {
+			if strings.Contains(err.Error(), "index: "+idx.name+" ") {
+				return duplicateKeyError{idx}
+			}
+		}
+	}
+	return fmt.Errorf("%w: %v", ErrDuplicate, err)
+}
@@ -0,0 +1,77 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+// checkProductRefs resolves the references of a product against the stores.
+// It reports every broken reference, not just the first: a category,
+// subcategory, brand or varient that does not exist, a subcategory outside
+// the product's category (at any depth) and a brand not assigned to the product's subcategory.
+func checkProductRefs(ctx context.Context, s Stores, sp subprod) ([]fieldError, error) {
+	var broken []fieldError
+	missing := func(field, entity, id string) {
+		broken = append(broken, fieldError{Field: field, Message: fmt.Sprintf("%s %s does not exist", entity, id)})
+	}
+
+	_, err := s.Categories.Get(ctx, sp.CategoryId)
+	categoryFound := err == nil
+	if errors.Is(err, ErrNotFound) {
+		missing("subprod.categoryid", "category", sp.CategoryId)
+	} else if err != nil {
+		return nil, err
+	}
+
+	subc, err := s.Categories.Get(ctx, sp.SubCategoryId) // the category node, for its path
+	if err == nil && subc.CParent == "" {
+		err = ErrNotFound // a top-level category is no subcategory
+	}
+	subcategoryFound := err == nil
+	if errors.Is(err, ErrNotFound) {
+		missing("subprod.subcategoryid", "subcategory", sp.SubCategoryId)
+	} else if err != nil {
+		return nil, err
+	} else if categoryFound && !contains(subc.CPath, sp.CategoryId) {
+		broken = append(broken, fieldError{Field: "subprod.subcategoryid", Message: fmt.Sprintf("subcategory %s is not under category %s", subc.CId, sp.CategoryId)})
+	}
+
+	_, err = s.Brands.Get(ctx, sp.BrandId)
+	if errors.Is(err, ErrNotFound) {
+		missing("subprod.brandid", "brand", sp.BrandId)
+	} else if err != nil {
+		return nil, err
+	} else if subcategoryFound {
+		assigned, err := s.BrandAssignments.Exists(ctx, assignmentKey(sp.BrandId, sp.SubCategoryId))
+		if err != nil {
+			return nil, err
+		}
+		if !assigned {
+			broken = append(broken, fieldError{Field: "subprod.brandid", Message: fmt.Sprintf("brand %s is not assigned to subcategory %s", sp.BrandId, sp.SubCategoryId)})
+		}
+	}
+
+	_, err = s.Varients.Get(ctx, sp.VarientId)
+	if errors.Is(err, ErrNotFound) {
+		missing("subprod.varientid", "varient", sp.VarientId)
+	} else if err != nil {
+		return nil, err
+	}
+
+	return broken, nil
+}
+
+// requireProductRefs is checkProductRefs for use inside a unit of work: broken
+// references come back as a 422 requestError.
+func requireProductRefs(ctx context.Context, s Stores, sp subprod) error {
+	broken, err := checkProductRefs(ctx, s, sp)
+	if err != nil {
+		return err
+	}
+	if len(broken) > 0 {
+		return requestError{http.StatusUnprocessableEntity, codeInvalidReference, "Invalid reference", broken}
+	}
+	return nil
+}
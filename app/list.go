@@ -0,0 +1,358 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+const (
+	defaultPageSize = 50
+	maxPageSize     = 500
+)
+
+// listFilter maps a GetAll query parameter to a document field. kind is
+// "string" or "bool" for an exact match, "min" or "max" for a numeric bound,
+// "blank" for a true/false choice between an empty and a set string field,
+// "since" or "until" for an RFC 3339 time bound, inclusive.
+type listFilter struct {
+	field string
+	kind  string
+}
+
+// filters accepted by each GetAll endpoint
+var (
+	productFilters = map[string]listFilter{
+		"status":    {"pstatus", "bool"},
+		"cid":       {"subprod.categoryid", "string"},
+		"scid":      {"subprod.subcategoryid", "string"},
+		"bid":       {"subprod.brandid", "string"},
+		"vid":       {"subprod.varientid", "string"},
+		"minPrice":  {"pprice", "min"},
+		"maxPrice":  {"pprice", "max"},
+		"createdBy": {"pcreatedby", "string"},
+	}
+	categoryFilters = map[string]listFilter{
+		"status":    {"cstatus", "bool"},
+		"createdBy": {"ccreatedby", "string"},
+		"parent":    {"cparent", "string"},
+		"ancestor":  {"cpath", "string"}, // every category below it, at any depth
+		"root":      {"cparent", "blank"},
+	}
+	subCategoryFilters = map[string]listFilter{
+		"status":    {"scstatus", "bool"},
+		"cid":       {"cid", "string"},
+		"createdBy": {"sccreatedby", "string"},
+	}
+	brandFilters = map[string]listFilter{
+		"status":    {"bstatus", "bool"},
+		"cid":       {"cid", "string"},
+		"scid":      {"scid", "string"},
+		"createdBy": {"bcreatedby", "string"},
+	}
+	varientFilters = map[string]listFilter{
+		"status":    {"vstatus", "bool"},
+		"createdBy": {"vcreatedby", "string"},
+	}
+	historyFilters = map[string]listFilter{
+		"user":      {"actor", "string"},
+		"action":    {"action", "string"},
+		"since":     {"at", "since"},
+		"until":     {"at", "until"},
+		"requestId": {"requestId", "string"},
+	}
+	auditFilters = map[string]listFilter{
+		"entity":    {"entity", "string"},
+		"id":        {"id", "string"},
+		"user":      {"actor", "string"},
+		"action":    {"action", "string"},
+		"since":     {"at", "since"},
+		"until":     {"at", "until"},
+		"requestId": {"requestId", "string"},
+	}
+)
+
+// page is the response of every GetAll endpoint. Next and Prev are links to
+// the neighbouring pages, left out at either end of the listing.
+type page[T any] struct {
+	Data   []T         `json:"data"`
+	Total  int64       `json:"total"` // documents matching the filters, on every page
+	Limit  int64       `json:"limit"`
+	Offset int64       `json:"offset,omitempty"`
+	Next   string      `json:"next,omitempty"`
+	Prev   string      `json:"prev,omitempty"`
+	Facets interface{} `json:"facets,omitempty"` // counts for the whole filter set, when asked for
+}
+
+// lister is the part of every entity store a GetAll endpoint needs.
+type lister[T any] interface {
+	Find(ctx context.Context, q findQuery) ([]T, error)
+	Count(ctx context.Context, filter bson.M) (int64, error)
+}
+
+// listRequest is a parsed GetAll query string.
+type listRequest struct {
+	query  findQuery
+	limit  int64
+	offset int64
+	after  *position
+	before *position
+	paged  bool // offset given, so links page by offset rather than cursor
+}
+
+// parseList reads limit, offset, after, before, sort and the given filters
+// from the query string. On a bad parameter it answers 400 and returns false.
+func parseList[T any](w http.ResponseWriter, r *http.Request, filters map[string]listFilter) (listRequest, bool) {
+	params := r.URL.Query()
+	req := listRequest{limit: defaultPageSize, query: findQuery{Filter: bson.M{}}}
+	var problems []fieldError
+	bad := func(param, message string) {
+		problems = append(problems, fieldError{Field: param, Message: message})
+	}
+
+	if v := params.Get("limit"); v != "" {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || n < 1 || n > maxPageSize {
+			bad("limit", fmt.Sprintf("limit must be a number from 1 to %d", maxPageSize))
+		}
+		req.limit = n
+	}
+	if v := params.Get("offset"); v != "" {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || n < 0 {
+			bad("offset", "offset must be a number from 0")
+		}
+		req.offset, req.paged = n, true
+	}
+	for _, name := range []string{"after", "before"} {
+		v := params.Get(name)
+		if v == "" {
+			continue
+		}
+		p, err := decodeCursor(v)
+		if err != nil {
+			bad(name, name+" is not a cursor returned by this endpoint")
+			continue
+		}
+		if name == "after" {
+			req.after = &p
+		} else {
+			req.before = &p
+		}
+	}
+	given := 0
+	for _, set := range []bool{req.paged, req.after != nil, req.before != nil} {
+		if set {
+			given++
+		}
+	}
+	if given > 1 {
+		bad("offset", "use only one of offset, after and before")
+	}
+
+	if v := params.Get("sort"); v != "" {
+		field := strings.TrimPrefix(v, "-")
+		if !sortableField[T](field) {
+			bad("sort", fmt.Sprintf("cannot sort by %q", field))
+		}
+		req.query.Sort, req.query.Desc = field, strings.HasPrefix(v, "-")
+	}
+
+	names := make([]string, 0, len(filters))
+	for param := range filters {
+		names = append(names, param)
+	}
+	sort.Strings(names) // report problems in a stable order
+	for _, param := range names {
+		f := filters[param]
+		v := params.Get(param)
+		if v == "" {
+			continue
+		}
+		switch f.kind {
+		case "bool":
+			b, err := strconv.ParseBool(v)
+			if err != nil {
+				bad(param, param+" must be true or false")
+			}
+			req.query.Filter[f.field] = b
+		case "blank":
+			b, err := strconv.ParseBool(v)
+			if err != nil {
+				bad(param, param+" must be true or false")
+			}
+			if b {
+				req.query.Filter[f.field] = ""
+			} else {
+				req.query.Filter[f.field] = bson.M{"$gt": ""}
+			}
+		case "min", "max", "since", "until":
+			var bound interface{}
+			if f.kind == "since" || f.kind == "until" {
+				t, err := time.Parse(time.RFC3339, v)
+				if err != nil {
+					bad(param, param+" must be an RFC 3339 time, such as 2024-01-31T09:00:00Z")
+				}
+				bound = t.UTC()
+			} else {
+				n, err := strconv.ParseFloat(v, 64)
+				if err != nil {
+					bad(param, param+" must be a number")
+				}
+				bound = n
+			}
+			op := "$gte"
+			if f.kind == "max" || f.kind == "until" {
+				op = "$lte"
+			}
+			bounds, _ := req.query.Filter[f.field].(bson.M)
+			if bounds == nil {
+				bounds = bson.M{}
+				req.query.Filter[f.field] = bounds
+			}
+			bounds[op] = bound
+		default:
+			req.query.Filter[f.field] = v
+		}
+	}
+
+	if len(problems) > 0 {
+		writeError(w, r, http.StatusBadRequest, codeBadRequest, "Invalid query parameters", problems...)
+		return req, false
+	}
+	return req, true
+}
+
+// sortableField reports whether field is the JSON name of a field of T,
+// nested ones included as "subprod.brandid". Times sort, other structs do not.
+func sortableField[T any](field string) bool {
+	t := reflect.TypeOf((*T)(nil)).Elem()
+	for _, name := range strings.Split(field, ".") {
+		if t.Kind() != reflect.Struct {
+			return false
+		}
+		found := false
+		for i := 0; i < t.NumField(); i++ {
+			if strings.SplitN(t.Field(i).Tag.Get("json"), ",", 2)[0] == name {
+				t, found = t.Field(i).Type, true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return t.Kind() != reflect.Struct || t == reflect.TypeOf(time.Time{})
+}
+
+// serveList answers a GetAll request: one page of the documents matching the
+// filters, their total count, links to the next and previous pages and, when
+// facets is not nil, its counts.
+// Without offset the links carry cursors, which stay stable while documents
+// are added or removed; with offset they page by position.
+func serveList[T any](w http.ResponseWriter, r *http.Request, s lister[T], key string, filters map[string]listFilter, facets facetFunc) {
+	req, ok := parseList[T](w, r, filters)
+	if !ok {
+		return
+	}
+	ctx := r.Context()
+	q := req.query
+	q.Limit = req.limit + 1 // one more tells whether another page follows
+	switch {
+	case req.before != nil:
+		q.Desc, q.After = !q.Desc, req.before // walk backwards, then flip the page
+	case req.after != nil:
+		q.After = req.after
+	default:
+		q.Skip = req.offset
+	}
+	items, err := s.Find(ctx, q)
+	if err != nil {
+		storeError(w, r, err)
+		return
+	}
+	more := int64(len(items)) > req.limit
+	if more {
+		items = items[:req.limit]
+	}
+	if req.before != nil {
+		for i, j := 0, len(items)-1; i < j; i, j = i+1, j-1 {
+			items[i], items[j] = items[j], items[i]
+		}
+	}
+	total, err := s.Count(ctx, req.query.Filter)
+	if err != nil {
+		storeError(w, r, err)
+		return
+	}
+
+	result := page[T]{Data: items, Total: total, Limit: req.limit}
+	if req.paged {
+		result.Offset = req.offset
+		if req.offset+int64(len(items)) < total {
+			result.Next = pageLink(r, "offset", strconv.FormatInt(req.offset+req.limit, 10))
+		}
+		if req.offset > 0 {
+			prev := req.offset - req.limit
+			if prev < 0 {
+				prev = 0
+			}
+			result.Prev = pageLink(r, "offset", strconv.FormatInt(prev, 10))
+		}
+	} else if len(items) > 0 {
+		hasNext, hasPrev := more, req.after != nil
+		if req.before != nil {
+			hasNext, hasPrev = true, more // the document at the before cursor follows
+		}
+		if hasNext {
+			result.Next = cursorLink(r, "after", items[len(items)-1], req.query.Sort, key)
+		}
+		if hasPrev {
+			result.Prev = cursorLink(r, "before", items[0], req.query.Sort, key)
+		}
+	}
+	if facets != nil {
+		if result.Facets, err = facets(ctx, req.query.Filter); err != nil {
+			storeError(w, r, err)
+			return
+		}
+	}
+	writeJSON(w, http.StatusOK, result)
+}
+
+// pageLink returns the request URL with param set to value and the other
+// paging parameters removed.
+func pageLink(r *http.Request, param, value string) string {
+	params := r.URL.Query()
+	for _, p := range []string{"offset", "after", "before"} {
+		params.Del(p)
+	}
+	params.Set(param, value)
+	u := url.URL{Path: r.URL.Path, RawQuery: params.Encode()}
+	return u.String()
+}
+
+func cursorLink(r *http.Request, param string, item interface{}, sortField, key string) string {
+	doc, err := toDoc(item)
+	if err != nil {
+		return ""
+	}
+	if sortField == "" {
+		sortField = key
+	}
+	v, _ := lookup(doc, sortField)
+	k, _ := doc[key].(string)
+	token, err := encodeCursor(position{v, k})
+	if err != nil {
+		return ""
+	}
+	return pageLink(r, param, token)
+}
@@ -0,0 +1,142 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// listVarients answers one GetAllVarient request for target and returns the
+// page it got.
+func listVarients(t *testing.T, s Stores, target string) page[varient] {
+	t.Helper()
+	w := httptest.NewRecorder()
+	serveList[varient](w, httptest.NewRequest(http.MethodGet, target, nil), s.Varients, "vid", varientFilters, nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("GET %s: %d %s", target, w.Code, w.Body)
+	}
+	var p page[varient]
+	if err := json.Unmarshal(w.Body.Bytes(), &p); err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+func vids(p page[varient]) []string {
+	out := []string{}
+	for _, v := range p.Data {
+		out = append(out, v.VId)
+	}
+	return out
+}
+
+// newVarients returns memory stores holding VAR01 to VAR07, named so that
+// by name they sort in the reverse order of their IDs; the odd ones are active.
+func newVarients(t *testing.T) Stores {
+	t.Helper()
+	s := NewMemoryStores()
+	for i := 1; i <= 7; i++ {
+		v := varient{VId: fmt.Sprintf("VAR%02d", i), Vname: fmt.Sprintf("Colour %c", 'H'-i), Vdesc: "a colour", Vcreatedby: "tester", Vmodifiedby: "tester", Vstatus: i%2 == 1}
+		if _, err := s.Varients.Insert(context.Background(), v); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return s
+}
+
+func TestCursorPaging(t *testing.T) {
+	tests := []struct {
+		name  string
+		query string
+		pages [][]string
+	}{
+		{"by key", "limit=3", [][]string{{"VAR01", "VAR02", "VAR03"}, {"VAR04", "VAR05", "VAR06"}, {"VAR07"}}},
+		{"descending", "limit=3&sort=-vid", [][]string{{"VAR07", "VAR06", "VAR05"}, {"VAR04", "VAR03", "VAR02"}, {"VAR01"}}},
+		{"by name", "limit=4&sort=vname", [][]string{{"VAR07", "VAR06", "VAR05", "VAR04"}, {"VAR03", "VAR02", "VAR01"}}},
+		{"filtered", "limit=2&status=true", [][]string{{"VAR01", "VAR03"}, {"VAR05", "VAR07"}}},
+		{"exact fit", "limit=7", [][]string{{"VAR01", "VAR02", "VAR03", "VAR04", "VAR05", "VAR06", "VAR07"}}},
+		{"nothing", "status=true&createdBy=nobody", [][]string{{}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newVarients(t)
+			target := "/api/GetAllVarient?" + tt.query
+			var links []string
+			for i, want := range tt.pages {
+				p := listVarients(t, s, target)
+				if got := vids(p); !equalPaths(got, want) {
+					t.Fatalf("page %d: got %v, want %v", i+1, got, want)
+				}
+				if (p.Prev != "") != (i > 0) {
+					t.Errorf("page %d: prev link %q", i+1, p.Prev)
+				}
+				if last := i == len(tt.pages)-1; (p.Next != "") == last {
+					t.Fatalf("page %d: next link %q", i+1, p.Next)
+				}
+				links = append(links, target)
+				target = p.Next
+			}
+			// and back again through the prev links
+			for i := len(tt.pages) - 1; i > 0; i-- {
+				p := listVarients(t, s, links[i])
+				if p.Prev == "" {
+					t.Fatalf("page %d: no prev link", i+1)
+				}
+				back := listVarients(t, s, p.Prev)
+				if got := vids(back); !equalPaths(got, tt.pages[i-1]) {
+					t.Errorf("before page %d: got %v, want %v", i+1, got, tt.pages[i-1])
+				}
+			}
+		})
+	}
+}
+
+func TestCursorPagingStable(t *testing.T) {
+	ctx := context.Background()
+	s := newVarients(t)
+	first := listVarients(t, s, "/api/GetAllVarient?limit=3")
+
+	// documents added or removed before the cursor do not shift the next page
+	if _, err := s.Varients.Delete(ctx, "VAR01", anyVersion); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Varients.Insert(ctx, varient{VId: "VAR00", Vname: "Colour Z", Vdesc: "a colour", Vcreatedby: "tester", Vmodifiedby: "tester"}); err != nil {
+		t.Fatal(err)
+	}
+	next := listVarients(t, s, first.Next)
+	if got, want := vids(next), []string{"VAR04", "VAR05", "VAR06"}; !equalPaths(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if next.Total != 7 {
+		t.Errorf("total %d, want 7", next.Total)
+	}
+}
+
+func TestOffsetPaging(t *testing.T) {
+	s := newVarients(t)
+	p := listVarients(t, s, "/api/GetAllVarient?limit=3&offset=3")
+	if got, want := vids(p), []string{"VAR04", "VAR05", "VAR06"}; !equalPaths(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if p.Next != "/api/GetAllVarient?limit=3&offset=6" || p.Prev != "/api/GetAllVarient?limit=3&offset=0" {
+		t.Errorf("links %q and %q", p.Next, p.Prev)
+	}
+	last := listVarients(t, s, p.Next)
+	if got := vids(last); !equalPaths(got, []string{"VAR07"}) || last.Next != "" {
+		t.Errorf("last page %v, next %q", got, last.Next)
+	}
+}
+
+func TestListBadParameters(t *testing.T) {
+	s := newVarients(t)
+	for _, query := range []string{"limit=0", "limit=501", "offset=-1", "after=xyz", "offset=1&after=xyz", "sort=colour", "status=maybe"} {
+		w := httptest.NewRecorder()
+		serveList[varient](w, httptest.NewRequest(http.MethodGet, "/api/GetAllVarient?"+query, nil), s.Varients, "vid", varientFilters, nil)
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("%s: %d, want 400", query, w.Code)
+		}
+	}
+}
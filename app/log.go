@@ -0,0 +1,41 @@
+package app
+
+import (
+	"fmt"
+	"log"
+	"strings"
+)
+
+// log levels, from most to least verbose
+const (
+	levelDebug = iota
+	levelInfo
+	levelWarn
+	levelError
+)
+
+var logLevels = map[string]int{"debug": levelDebug, "info": levelInfo, "warn": levelWarn, "error": levelError}
+
+var currentLogLevel = levelInfo
+
+// SetLogLevel sets the minimum level (debug, info, warn or error) that is written to the log.
+func SetLogLevel(name string) error {
+	level, ok := logLevels[strings.ToLower(name)]
+	if !ok {
+		return fmt.Errorf("unknown log level %q", name)
+	}
+	currentLogLevel = level
+	return nil
+}
+
+func logf(level int, prefix string, format string, v ...interface{}) {
+	if level < currentLogLevel {
+		return
+	}
+	log.Output(3, prefix+fmt.Sprintf(format, v...))
+}
+
+func logDebugf(format string, v ...interface{}) { logf(levelDebug, "DEBUG ", format, v...) }
+func logInfof(format string, v ...interface{})  { logf(levelInfo, "INFO ", format, v...) }
+func logWarnf(format string, v ...interface{})  { logf(levelWarn, "WARN ", format, v...) }
+func logErrorf(format string, v ...interface{}) { logf(levelError, "ERROR ", format, v...) }
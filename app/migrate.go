@@ -0,0 +1,155 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// migrateSubCategories brings a database from before nested categories up to
+// date: top-level categories get an empty parent and path, and the documents
+// of the old subcategory collection are copied into the category collection
+// as categories with a parent, after which the old collection is renamed to
+// its backup name. dropMigrationBackup removes the backup once the operator
+// asks for it. A run cut short is finished by the next one, as copied
+// documents are skipped.
+func migrateSubCategories(ctx context.Context, db *mongo.Database, names CollectionNames) error {
+	cats := db.Collection(names.Category)
+	if _, err := cats.UpdateMany(ctx, bson.M{"cparent": bson.M{"$exists": false}}, bson.M{"$set": bson.M{"cparent": "", "cpath": bson.A{}}}); err != nil {
+		return err
+	}
+	if names.SubCategory == names.Category {
+		return nil
+	}
+	old := db.Collection(names.SubCategory)
+	cur, err := old.Find(ctx, bson.M{})
+	if err != nil {
+		return err
+	}
+	var subs []subcategory
+	if err := cur.All(ctx, &subs); err != nil {
+		return err
+	}
+	if len(subs) == 0 {
+		return nil
+	}
+	copied := 0
+	for _, sc := range subs {
+		var existing category
+		err := cats.FindOne(ctx, bson.M{"cid": sc.ScId}).Decode(&existing)
+		if err == nil {
+			if existing.CParent != sc.CId {
+				return fmt.Errorf("migrating subcategory %s: a category with that ID already exists", sc.ScId)
+			}
+			continue // copied by an earlier run
+		}
+		if err != mongo.ErrNoDocuments {
+			return err
+		}
+		// the old model had a single level, so every parent is at the top
+		node := category{CId: sc.ScId, CParent: sc.CId, CPath: []string{sc.CId}, Cname: sc.Scname, Cdesc: sc.Scdesc, Ccreatedby: sc.Sccreatedby, Cmodifiedby: sc.Scmodifiedby, Cstatus: sc.Scstatus}
+		if _, err := cats.InsertOne(ctx, node); err != nil {
+			return err
+		}
+		copied++
+	}
+	logInfof("moved %d subcategories from %s into %s", copied, names.SubCategory, names.Category)
+	backup := migrationBackup(names)
+	rename := bson.D{
+		{Key: "renameCollection", Value: db.Name() + "." + names.SubCategory},
+		{Key: "to", Value: db.Name() + "." + backup},
+	}
+	if err := db.Client().Database("admin").RunCommand(ctx, rename).Err(); err != nil {
+		return fmt.Errorf("keeping %s as %s: %w", names.SubCategory, backup, err)
+	}
+	logInfof("kept the old subcategories as %s; set mongo.dropMigrationBackup to drop it", backup)
+	return nil
+}
+
+// migrationBackup names the collection the old subcategories are kept in.
+func migrationBackup(names CollectionNames) string {
+	return names.SubCategory + "_premigration"
+}
+
+// dropMigrationBackup drops the old subcategories kept by migrateSubCategories,
+// if there are any.
+func dropMigrationBackup(ctx context.Context, db *mongo.Database, names CollectionNames) error {
+	if names.SubCategory == names.Category {
+		return nil
+	}
+	return db.Collection(migrationBackup(names)).Drop(ctx)
+}
+
+// migrateBrandAssignments turns the single subcategory of brands from before
+// assignments into an assignment, then removes scid and cid from the brand.
+// Assignments are upserted by key, so a run cut short is finished by the next one.
+func migrateBrandAssignments(ctx context.Context, db *mongo.Database, names CollectionNames) error {
+	brands := db.Collection(names.Brand)
+	cur, err := brands.Find(ctx, bson.M{"scid": bson.M{"$exists": true}})
+	if err != nil {
+		return err
+	}
+	var old []struct {
+		BId  string `bson:"bid"`
+		ScId string `bson:"scid"`
+	}
+	if err := cur.All(ctx, &old); err != nil {
+		return err
+	}
+	if len(old) == 0 {
+		return nil
+	}
+	assignments := db.Collection(names.BrandAssignment)
+	for _, b := range old {
+		if b.ScId == "" {
+			continue
+		}
+		a := brandAssignment{assignmentKey(b.BId, b.ScId), b.BId, b.ScId}
+		if _, err := assignments.ReplaceOne(ctx, bson.M{"key": a.Key}, a, options.Replace().SetUpsert(true)); err != nil {
+			return err
+		}
+	}
+	if _, err := brands.UpdateMany(ctx, bson.M{"scid": bson.M{"$exists": true}}, bson.M{"$unset": bson.M{"scid": "", "cid": ""}}); err != nil {
+		return err
+	}
+	logInfof("assigned %d brands to their subcategory in %s", len(old), names.BrandAssignment)
+	return nil
+}
+
+// migrateVersions gives documents from before versions, or copied in by the
+// migrations above, the first one, so that every document has an ETag a
+// write can be checked against.
+func migrateVersions(ctx context.Context, db *mongo.Database, names CollectionNames) error {
+	for _, name := range []string{names.Product, names.Category, names.Brand, names.Varient} {
+		res, err := db.Collection(name).UpdateMany(ctx, bson.M{"version": bson.M{"$in": bson.A{nil, 0}}}, bson.M{"$set": bson.M{"version": int64(1)}})
+		if err != nil {
+			return err
+		}
+		if res.ModifiedCount > 0 {
+			logInfof("set the first version on %d documents of %s", res.ModifiedCount, name)
+		}
+	}
+	return nil
+}
+
+// migrateTimestamps gives documents from before timestamps, or copied in by
+// the migrations above, a createdAt and updatedAt: the time in their ObjectID, when they were inserted, as the
+// time of their last change is not known. Needs MongoDB 4.2 or later.
+func migrateTimestamps(ctx context.Context, db *mongo.Database, names CollectionNames) error {
+	inserted := bson.M{"$toDate": "$_id"}
+	stamp := mongo.Pipeline{{{Key: "$set", Value: bson.M{"createdAt": inserted, "updatedAt": inserted}}}}
+	for _, name := range []string{names.Product, names.Category, names.Brand, names.Varient} {
+		res, err := db.Collection(name).UpdateMany(ctx, bson.M{"createdAt": bson.M{"$in": bson.A{nil, time.Time{}}}}, stamp)
+		if err != nil {
+			return err
+		}
+		if res.ModifiedCount > 0 {
+			logInfof("set createdAt and updatedAt on %d documents of %s", res.ModifiedCount, name)
+		}
+	}
+	return nil
+}
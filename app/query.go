@@ -0,0 +1,65 @@
+package app
+
+import (
+	"encoding/base64"
+	"errors"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// findQuery selects one page of a collection. Filter values are either the
+// exact value a field must hold or a bson.M of $gt, $gte, $lt and $lte
+// bounds or $in values; dotted names such as "subprod.brandid" reach nested fields.
+// Documents are ordered by Sort, then by business key to break ties.
+type findQuery struct {
+	Filter bson.M
+	Sort   string // field to order by; the business key when empty
+	Desc   bool
+	After  *position // when set, only documents strictly after it in that order
+	Skip   int64
+	Limit  int64 // 0 for no limit
+}
+
+// position is where a document sits in a sorted listing: its value of the
+// sort field and its business key.
+type position struct {
+	Value interface{} `bson:"v"`
+	Key   string      `bson:"k"`
+}
+
+var errBadCursor = errors.New("malformed cursor")
+
+// encodeCursor turns a position into an opaque token. It is BSON underneath,
+// so the sort value keeps its type across requests.
+func encodeCursor(p position) (string, error) {
+	raw, err := bson.Marshal(p)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(raw), nil
+}
+
+func decodeCursor(token string) (position, error) {
+	var p position
+	raw, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil || bson.Unmarshal(raw, &p) != nil {
+		return p, errBadCursor
+	}
+	return p, nil
+}
+
+// afterFilter selects the documents that come after p when sorting by field
+// then key, in the given direction.
+func afterFilter(field, key string, desc bool, p position) bson.M {
+	op := "$gt"
+	if desc {
+		op = "$lt"
+	}
+	if field == key {
+		return bson.M{key: bson.M{op: p.Key}}
+	}
+	return bson.M{"$or": bson.A{
+		bson.M{field: bson.M{op: p.Value}},
+		bson.M{field: p.Value, key: bson.M{op: p.Key}},
+	}}
+}
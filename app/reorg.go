@@ -0,0 +1,380 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// nodeMove is a category given a new parent or path.
+type nodeMove struct {
+	CId  string   `json:"cid"`
+	From string   `json:"from"` // previous parent, empty at the top
+	To   string   `json:"to"`
+	Path []string `json:"cpath"`
+}
+
+// repoint is one reference of a brand assignment or product changed to another category.
+type repoint struct {
+	docRef
+	Field string `json:"field"`
+	From  string `json:"from"`
+	To    string `json:"to"`
+}
+
+type assignmentChange struct {
+	before brandAssignment
+	scid   string
+}
+
+type productChange struct {
+	before product
+	refs   subprod
+}
+
+// reorgPlan is everything a move or merge changes. It is what a dry run and
+// a completed reorganization both return.
+type reorgPlan struct {
+	Moved     []nodeMove   `json:"moved"`
+	Repointed []repoint    `json:"repointed"`
+	Deleted   []docRef     `json:"deleted"` // the category merged away
+	Blocked   []blockedRef `json:"blocked"` // documents that would be left without a subcategory
+
+	assigned []assignmentChange
+	products []productChange
+}
+
+// loadTree returns every category by ID.
+func loadTree(ctx context.Context, s Stores) (map[string]category, error) {
+	all, err := s.Categories.Find(ctx, findQuery{Filter: bson.M{}})
+	if err != nil {
+		return nil, err
+	}
+	nodes := make(map[string]category, len(all))
+	for _, c := range all {
+		nodes[c.CId] = c
+	}
+	return nodes, nil
+}
+
+// target looks up the category a move or merge goes to, and refuses one inside the subtree of id.
+func target(nodes map[string]category, id, to string) error {
+	t, ok := nodes[to]
+	if !ok {
+		return errNoTarget
+	}
+	if to == id || contains(t.CPath, id) {
+		return errCycle
+	}
+	return nil
+}
+
+// planMove plans placing the category id under parent, or at the top when parent is empty.
+func planMove(ctx context.Context, s Stores, id, parent string) (reorgPlan, error) {
+	nodes, err := loadTree(ctx, s)
+	if err != nil {
+		return reorgPlan{}, err
+	}
+	if _, ok := nodes[id]; !ok {
+		return reorgPlan{}, ErrNotFound
+	}
+	if parent != "" {
+		if err := target(nodes, id, parent); err != nil {
+			return reorgPlan{}, err
+		}
+	}
+	parents := map[string]string{}
+	for cid, c := range nodes {
+		parents[cid] = c.CParent
+	}
+	parents[id] = parent
+	return planReorg(ctx, s, nodes, parents, nil)
+}
+
+// planMerge plans folding the category from into the category into: its
+// children move under into, references to it are re-pointed and it is deleted.
+func planMerge(ctx context.Context, s Stores, from, into string) (reorgPlan, error) {
+	nodes, err := loadTree(ctx, s)
+	if err != nil {
+		return reorgPlan{}, err
+	}
+	if _, ok := nodes[from]; !ok {
+		return reorgPlan{}, ErrNotFound
+	}
+	if err := target(nodes, from, into); err != nil {
+		return reorgPlan{}, err
+	}
+	parents := map[string]string{}
+	for cid, c := range nodes {
+		if c.CParent == from {
+			parents[cid] = into
+		} else {
+			parents[cid] = c.CParent
+		}
+	}
+	delete(parents, from)
+	plan, err := planReorg(ctx, s, nodes, parents, map[string]string{from: into})
+	plan.Deleted = append(plan.Deleted, docRef{"category", from})
+	return plan, err
+}
+
+// planReorg compares the tree given by parents with the stored one and works
+// out the paths to rewrite. Brand assignments and products follow: IDs in replace are
+// swapped for their replacement, and a category that no longer lies above
+// the subcategory becomes the subcategory's top-level category.
+func planReorg(ctx context.Context, s Stores, nodes map[string]category, parents map[string]string, replace map[string]string) (reorgPlan, error) {
+	plan := reorgPlan{Moved: []nodeMove{}, Repointed: []repoint{}, Deleted: []docRef{}, Blocked: []blockedRef{}}
+
+	paths := map[string][]string{}
+	var pathOf func(id string) []string
+	pathOf = func(id string) []string {
+		if p, ok := paths[id]; ok {
+			return p
+		}
+		p := []string{}
+		if parent := parents[id]; parent != "" {
+			p = append(append(p, pathOf(parent)...), parent)
+		}
+		paths[id] = p
+		return p
+	}
+	affected := []string{}
+	for id := range replace {
+		affected = append(affected, id)
+	}
+	for id, parent := range parents {
+		before := nodes[id]
+		path := pathOf(id)
+		if parent != before.CParent || !equalPaths(path, before.CPath) {
+			plan.Moved = append(plan.Moved, nodeMove{id, before.CParent, parent, path})
+			affected = append(affected, id)
+		}
+	}
+	sort.Slice(plan.Moved, func(i, j int) bool { // parents first, for a readable report
+		a, b := plan.Moved[i], plan.Moved[j]
+		if len(a.Path) != len(b.Path) {
+			return len(a.Path) < len(b.Path)
+		}
+		return a.CId < b.CId
+	})
+	if len(affected) == 0 {
+		return plan, nil
+	}
+
+	// resolve returns the new category and subcategory of a reference, or
+	// false when the subcategory would end up at the top.
+	resolve := func(cid, scid string) (string, string, bool) {
+		if r, ok := replace[cid]; ok {
+			cid = r
+		}
+		if r, ok := replace[scid]; ok {
+			scid = r
+		}
+		if parents[scid] == "" {
+			return cid, scid, false
+		}
+		if path := pathOf(scid); !contains(path, cid) {
+			cid = path[0]
+		}
+		return cid, scid, true
+	}
+	change := func(ref docRef, field, from, to string) {
+		if from != to {
+			plan.Repointed = append(plan.Repointed, repoint{ref, field, from, to})
+		}
+	}
+
+	assignments, err := s.BrandAssignments.Find(ctx, findQuery{Filter: bson.M{"scid": bson.M{"$in": affected}}})
+	if err != nil {
+		return plan, err
+	}
+	for _, a := range assignments {
+		ref := docRef{"assignment", a.Key}
+		_, scid, ok := resolve("", a.ScId)
+		if !ok {
+			plan.Blocked = append(plan.Blocked, blockedRef{ref, docRef{"subcategory", scid}})
+			continue
+		}
+		if scid != a.ScId {
+			change(ref, "scid", a.ScId, scid)
+			plan.assigned = append(plan.assigned, assignmentChange{a, scid})
+		}
+	}
+
+	seen := map[string]bool{}
+	for _, field := range []string{"subprod.subcategoryid", "subprod.categoryid"} {
+		products, err := s.Products.Find(ctx, findQuery{Filter: bson.M{field: bson.M{"$in": affected}}})
+		if err != nil {
+			return plan, err
+		}
+		for _, p := range products {
+			if seen[p.PId] {
+				continue
+			}
+			seen[p.PId] = true
+			ref := docRef{"product", p.PId}
+			cid, scid, ok := resolve(p.SubProd.CategoryId, p.SubProd.SubCategoryId)
+			if !ok {
+				plan.Blocked = append(plan.Blocked, blockedRef{ref, docRef{"subcategory", scid}})
+				continue
+			}
+			if cid != p.SubProd.CategoryId || scid != p.SubProd.SubCategoryId {
+				change(ref, "subprod.subcategoryid", p.SubProd.SubCategoryId, scid)
+				change(ref, "subprod.categoryid", p.SubProd.CategoryId, cid)
+				refs := p.SubProd
+				refs.CategoryId, refs.SubCategoryId = cid, scid
+				plan.products = append(plan.products, productChange{p, refs})
+			}
+		}
+	}
+	return plan, nil
+}
+
+func equalPaths(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// apply carries out the plan. A failed write is rolled back with the rest by
+// the unit of work; without transactions, which the Mongo store only runs
+// when allowNonTransactional is set, the writes already made stay.
+func (plan reorgPlan) apply(ctx context.Context, s Stores) error {
+	for _, m := range plan.Moved {
+		if _, err := s.Categories.Move(ctx, m.CId, m.To, m.Path); err != nil {
+			return err
+		}
+	}
+	for _, c := range plan.assigned {
+		after := brandAssignment{assignmentKey(c.before.BId, c.scid), c.before.BId, c.scid}
+		if _, err := s.BrandAssignments.Delete(ctx, c.before.Key, anyVersion); err != nil {
+			return err
+		}
+		// the brand may already be assigned to the category merged into
+		exists, err := s.BrandAssignments.Exists(ctx, after.Key)
+		if err != nil {
+			return err
+		}
+		if exists {
+			continue
+		}
+		if _, err := s.BrandAssignments.Insert(ctx, after); err != nil {
+			return err
+		}
+	}
+	for _, c := range plan.products {
+		p := c.before
+		if _, err := s.Products.Update(ctx, productUpdate{p.PId, p.Pname, p.Pdesc, p.Pqty, p.Pmrp, p.Pprice, p.Pmodifiedby, c.refs}, anyVersion); err != nil {
+			return err
+		}
+	}
+	for _, ref := range plan.Deleted {
+		if _, err := s.Categories.Delete(ctx, ref.ID, anyVersion); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// reorganize serves the move and merge endpoints. With ?dryRun=true it only
+// reports the plan; field names the target in the request body. If-Match
+// applies to ref, the category moved or merged away.
+func (h *Handler) reorganize(w http.ResponseWriter, r *http.Request, field string, ref docRef, plan func(ctx context.Context) (reorgPlan, error)) {
+	dryRun, ok := dryRunParam(w, r)
+	if !ok {
+		return
+	}
+	version, ok := ifMatch(w, r)
+	if !ok {
+		return
+	}
+	var p reorgPlan
+	err := h.store.Tx.Run(r.Context(), func(ctx context.Context) error { // planned and applied on the same state
+		err := requireVersion(ctx, h.store, ref, version)
+		if err != nil {
+			return err
+		}
+		if p, err = plan(ctx); err != nil || dryRun {
+			return err
+		}
+		return p.carryOut(ctx, h.store)
+	})
+	if err != nil {
+		moveError(w, r, err, field)
+		return
+	}
+	writeJSON(w, http.StatusOK, p)
+}
+
+// carryOut applies the plan unless documents block it, which answers 409.
+func (plan reorgPlan) carryOut(ctx context.Context, s Stores) error {
+	if len(plan.Blocked) > 0 {
+		details := make([]fieldError, 0, len(plan.Blocked))
+		for _, b := range plan.Blocked {
+			details = append(details, fieldError{Field: b.Entity, Message: fmt.Sprintf("%s %s would have top-level category %s as its subcategory", b.Entity, b.ID, b.References.ID)})
+		}
+		return requestError{http.StatusConflict, codeHasDependents, "Documents would be left without a subcategory", details}
+	}
+	if err := plan.apply(ctx, s); err != nil {
+		return err
+	}
+	logInfof("reorganized catalog: %d categories moved, %d references changed, %d deleted", len(plan.Moved), len(plan.Repointed), len(plan.Deleted))
+	return nil
+}
+
+// Move Category
+
+func (h *Handler) MoveCategory(w http.ResponseWriter, r *http.Request) {
+	type moveBody struct {
+		CId     string `json:"cid" validate:"required,alphanum,min=4,max=26"`      //value that has to be matched
+		CParent string `json:"cparent" validate:"omitempty,alphanum,min=4,max=26"` // new parent, empty to move to the top
+	}
+	var body moveBody
+	if !decodeBody(w, r, &body) || !validateBody(w, r, body) { // move struct validation
+		return
+	}
+	h.reorganize(w, r, "cparent", docRef{"category", body.CId}, func(ctx context.Context) (reorgPlan, error) {
+		return planMove(ctx, h.store, body.CId, body.CParent)
+	})
+}
+
+// Move SubCategory to another Category
+
+func (h *Handler) MoveSubCategory(w http.ResponseWriter, r *http.Request) {
+	type moveBody struct {
+		ScId string `json:"scid" validate:"required,alphanum,min=4,max=26"` //value that has to be matched
+		CId  string `json:"cid" validate:"required,alphanum,min=4,max=26"`  // new parent
+	}
+	var body moveBody
+	if !decodeBody(w, r, &body) || !validateBody(w, r, body) { // move struct validation
+		return
+	}
+	h.reorganize(w, r, "cid", docRef{"subcategory", body.ScId}, func(ctx context.Context) (reorgPlan, error) {
+		return planMove(ctx, h.store, body.ScId, body.CId)
+	})
+}
+
+// Merge Category into another
+
+func (h *Handler) MergeCategory(w http.ResponseWriter, r *http.Request) {
+	type mergeBody struct {
+		CId  string `json:"cid" validate:"required,alphanum,min=4,max=26"`  // category that goes away
+		Into string `json:"into" validate:"required,alphanum,min=4,max=26"` // category that takes its place
+	}
+	var body mergeBody
+	if !decodeBody(w, r, &body) || !validateBody(w, r, body) { // merge struct validation
+		return
+	}
+	h.reorganize(w, r, "into", docRef{"category", body.CId}, func(ctx context.Context) (reorgPlan, error) {
+		return planMerge(ctx, h.store, body.CId, body.Into)
+	})
+}
@@ -0,0 +1,217 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+)
+
+// describe flattens a plan into strings, for comparing with want lists.
+func describe(plan reorgPlan) (moved, repointed, blocked []string) {
+	for _, m := range plan.Moved {
+		moved = append(moved, fmt.Sprintf("%s %s>%s %v", m.CId, m.From, m.To, m.Path))
+	}
+	for _, r := range plan.Repointed {
+		repointed = append(repointed, fmt.Sprintf("%s:%s %s %s>%s", r.Entity, r.ID, r.Field, r.From, r.To))
+	}
+	for _, b := range plan.Blocked {
+		pair := refs([]docRef{b.docRef, b.References})
+		blocked = append(blocked, pair[0]+">"+pair[1])
+	}
+	return moved, repointed, blocked
+}
+
+func TestPlanMove(t *testing.T) {
+	tests := []struct {
+		name      string
+		id, to    string
+		err       error
+		moved     []string
+		repointed []string
+		blocked   []string
+	}{
+		{
+			name:  "to the top",
+			id:    "SUB4",
+			moved: []string{"SUB4 SUB1> []"},
+		},
+		{
+			name:      "subtree to another category",
+			id:        "SUB1",
+			to:        "CAT2",
+			moved:     []string{"SUB1 CAT1>CAT2 [CAT2]", "SUB4 SUB1>SUB1 [CAT2 SUB1]"},
+			repointed: []string{"product:PRD1 subprod.categoryid CAT1>CAT2"},
+		},
+		{
+			name:    "leaving its brands and products without a subcategory",
+			id:      "SUB1",
+			moved:   []string{"SUB1 CAT1> []", "SUB4 SUB1>SUB1 [SUB1]"},
+			blocked: []string{"assignment:BRD1:SUB1>subcategory:SUB1", "assignment:BRD2:SUB1>subcategory:SUB1", "product:PRD1>subcategory:SUB1"},
+		},
+		{
+			name:  "a top category under another",
+			id:    "CAT2",
+			to:    "CAT1",
+			moved: []string{"CAT2 >CAT1 [CAT1]", "SUB3 CAT2>CAT2 [CAT1 CAT2]"}, // PRD2's category still lies above SUB3
+		},
+		{name: "where it is", id: "SUB2", to: "CAT1"},
+		{name: "under itself", id: "SUB1", to: "SUB1", err: errCycle},
+		{name: "under its own subtree", id: "CAT1", to: "SUB4", err: errCycle},
+		{name: "under a missing category", id: "SUB1", to: "NOPE", err: errNoTarget},
+		{name: "missing category", id: "NOPE", to: "CAT1", err: ErrNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			plan, err := planMove(context.Background(), newCatalog(t), tt.id, tt.to)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("error %v, want %v", err, tt.err)
+			}
+			moved, repointed, blocked := describe(plan)
+			if !equalPaths(moved, tt.moved) {
+				t.Errorf("moved %v, want %v", moved, tt.moved)
+			}
+			if !equalPaths(repointed, tt.repointed) {
+				t.Errorf("repointed %v, want %v", repointed, tt.repointed)
+			}
+			if !equalPaths(blocked, tt.blocked) {
+				t.Errorf("blocked %v, want %v", blocked, tt.blocked)
+			}
+		})
+	}
+}
+
+func TestPlanMerge(t *testing.T) {
+	tests := []struct {
+		name       string
+		from, into string
+		err        error
+		moved      []string
+		repointed  []string
+	}{
+		{
+			name:      "top categories",
+			from:      "CAT2",
+			into:      "CAT1",
+			moved:     []string{"SUB3 CAT2>CAT1 [CAT1]"},
+			repointed: []string{"product:PRD2 subprod.categoryid CAT2>CAT1"},
+		},
+		{
+			name:      "subcategories under one category",
+			from:      "SUB2",
+			into:      "SUB1",
+			repointed: []string{"product:PRD3 subprod.subcategoryid SUB2>SUB1"},
+		},
+		{
+			name:      "subcategories under different categories",
+			from:      "SUB3",
+			into:      "SUB1",
+			repointed: []string{"assignment:BRD2:SUB3 scid SUB3>SUB1", "product:PRD2 subprod.subcategoryid SUB3>SUB1", "product:PRD2 subprod.categoryid CAT2>CAT1"},
+		},
+		{
+			name:      "with children",
+			from:      "SUB1",
+			into:      "SUB2",
+			moved:     []string{"SUB4 SUB1>SUB2 [CAT1 SUB2]"},
+			repointed: []string{"assignment:BRD1:SUB1 scid SUB1>SUB2", "assignment:BRD2:SUB1 scid SUB1>SUB2", "product:PRD1 subprod.subcategoryid SUB1>SUB2"},
+		},
+		{name: "into itself", from: "SUB1", into: "SUB1", err: errCycle},
+		{name: "into its own subtree", from: "CAT1", into: "SUB4", err: errCycle},
+		{name: "into a missing category", from: "SUB1", into: "NOPE", err: errNoTarget},
+		{name: "missing category", from: "NOPE", into: "CAT1", err: ErrNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			plan, err := planMerge(context.Background(), newCatalog(t), tt.from, tt.into)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("error %v, want %v", err, tt.err)
+			}
+			if err != nil {
+				return
+			}
+			moved, repointed, blocked := describe(plan)
+			if !equalPaths(moved, tt.moved) {
+				t.Errorf("moved %v, want %v", moved, tt.moved)
+			}
+			if !equalPaths(repointed, tt.repointed) {
+				t.Errorf("repointed %v, want %v", repointed, tt.repointed)
+			}
+			if len(blocked) > 0 {
+				t.Errorf("blocked %v", blocked)
+			}
+			if got := refs(plan.Deleted); !equalPaths(got, []string{"category:" + tt.from}) {
+				t.Errorf("deleted %v", got)
+			}
+		})
+	}
+}
+
+func TestMergeApply(t *testing.T) {
+	ctx := context.Background()
+	s := newCatalog(t)
+	err := s.Tx.Run(ctx, func(ctx context.Context) error {
+		plan, err := planMerge(ctx, s, "SUB3", "SUB1")
+		if err != nil {
+			return err
+		}
+		return plan.apply(ctx, s)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Categories.Get(ctx, "SUB3"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("SUB3: %v, want ErrNotFound", err)
+	}
+	assignments, err := s.BrandAssignments.Find(ctx, findQuery{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var keys []string
+	for _, a := range assignments {
+		keys = append(keys, a.Key)
+	}
+	if want := []string{"BRD1:SUB1", "BRD2:SUB1"}; !equalPaths(keys, want) { // BRD2 was in both already
+		t.Errorf("assignments %v, want %v", keys, want)
+	}
+	p, err := s.Products.Get(ctx, "PRD2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.SubProd.CategoryId != "CAT1" || p.SubProd.SubCategoryId != "SUB1" {
+		t.Errorf("PRD2 in %s/%s, want CAT1/SUB1", p.SubProd.CategoryId, p.SubProd.SubCategoryId)
+	}
+}
+
+func TestMergeRollsBack(t *testing.T) {
+	ctx := context.Background()
+	s := newCatalog(t)
+	// a product named like PRD2 in SUB1 makes re-pointing PRD2 fail on the unique
+	// name, after BRD2's assignment to SUB3 has been removed
+	clash := product{PId: "PRD4", Pname: "Tab Y", Pdesc: "a product", Pqty: 1, Pmrp: 10, Pprice: 9, Pcreatedby: "tester", Pmodifiedby: "tester", SubProd: subprod{"CAT1", "SUB1", "BRD1", "VAR1"}}
+	if _, err := s.Products.Insert(ctx, clash); err != nil {
+		t.Fatal(err)
+	}
+	err := s.Tx.Run(ctx, func(ctx context.Context) error {
+		plan, err := planMerge(ctx, s, "SUB3", "SUB1")
+		if err != nil {
+			return err
+		}
+		return plan.apply(ctx, s)
+	})
+	if !errors.Is(err, ErrDuplicate) {
+		t.Fatalf("error %v, want ErrDuplicate", err)
+	}
+	if exists, err := s.BrandAssignments.Exists(ctx, "BRD2:SUB3"); err != nil || !exists {
+		t.Errorf("assignment BRD2:SUB3 is gone (%v)", err)
+	}
+	if exists, err := s.Categories.Exists(ctx, "SUB3"); err != nil || !exists {
+		t.Errorf("SUB3 is gone (%v)", err)
+	}
+	p, err := s.Products.Get(ctx, "PRD2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.SubProd.SubCategoryId != "SUB3" || p.Version != 1 {
+		t.Errorf("PRD2 in %s at version %d, want left as it was", p.SubProd.SubCategoryId, p.Version)
+	}
+}
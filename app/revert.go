@@ -0,0 +1,154 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// findRevision returns the document ref as it was at revision, from the
+// audit log. A document deleted and created again starts its revisions over,
+// so the latest entry for the revision is the one of the document as it is now.
+func findRevision(ctx context.Context, s Stores, ref docRef, revision int64) (bson.M, error) {
+	entities := []string{ref.Entity}
+	if ref.Entity == "category" || ref.Entity == "subcategory" {
+		entities = []string{"category", "subcategory"}
+	}
+	entries, err := s.Audit.Find(ctx, findQuery{Filter: bson.M{"id": ref.ID, "entity": bson.M{"$in": entities}, "revision": revision}, Desc: true})
+	if err != nil {
+		return nil, err
+	}
+	for _, e := range entries {
+		if e.After != nil { // a delete leaves no document at its revision
+			return e.After, nil
+		}
+	}
+	return nil, requestError{http.StatusNotFound, codeNotFound, fmt.Sprintf("Revision %d is not in the history", revision), nil}
+}
+
+// revert writes the fields of ref back to what they were at revision, or at
+// the revision before the current one when revision is 0, after checking the
+// references the restored fields make. It returns the document as reverted.
+func revert(ctx context.Context, s Stores, ref docRef, revision, version int64) (versioned, error) {
+	var current int64
+	switch ref.Entity {
+	case "product":
+		p, err := s.Products.Get(ctx, ref.ID)
+		if err != nil {
+			return nil, err
+		}
+		current = p.Version
+	case "category", "subcategory": // either is a node of the category collection
+		c, err := s.Categories.Get(ctx, ref.ID)
+		if err != nil {
+			return nil, err
+		}
+		if (c.CParent == "") != (ref.Entity == "category") {
+			return nil, ErrNotFound
+		}
+		current = c.Version
+	case "brand":
+		b, err := s.Brands.Get(ctx, ref.ID)
+		if err != nil {
+			return nil, err
+		}
+		current = b.Version
+	default:
+		v, err := s.Varients.Get(ctx, ref.ID)
+		if err != nil {
+			return nil, err
+		}
+		current = v.Version
+	}
+	if version != anyVersion && version != current {
+		return nil, ErrVersionMismatch
+	}
+	if revision == 0 {
+		revision = current - 1
+	}
+	if revision < 1 || revision >= current {
+		message := fmt.Sprintf("revision must be earlier than the current revision %d", current)
+		if current == 1 {
+			message = "the document has not changed since it was created"
+		}
+		return nil, requestError{http.StatusUnprocessableEntity, codeValidation, "Validation error", []fieldError{{Field: "revision", Message: message}}}
+	}
+	doc, err := findRevision(ctx, s, ref, revision)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx = reverting(ctx, revision)
+	switch ref.Entity {
+	case "product":
+		p, err := fromDoc[product](doc)
+		if err != nil {
+			return nil, err
+		}
+		if err := requireProductRefs(ctx, s, p.SubProd); err != nil {
+			return nil, err
+		}
+		return s.Products.Restore(ctx, p, current)
+	case "category", "subcategory":
+		c, err := fromDoc[category](doc)
+		if err != nil {
+			return nil, err
+		}
+		if c, err = s.Categories.Restore(ctx, c, current); err != nil || ref.Entity == "category" {
+			return c, err
+		}
+		return asSubCategory(c), nil
+	case "brand":
+		b, err := fromDoc[brand](doc)
+		if err != nil {
+			return nil, err
+		}
+		return s.Brands.Restore(ctx, b, current)
+	default:
+		v, err := fromDoc[varient](doc)
+		if err != nil {
+			return nil, err
+		}
+		return s.Varients.Restore(ctx, v, current)
+	}
+}
+
+// Revert to a previous revision
+
+func (h *Handler) Revert(w http.ResponseWriter, r *http.Request) {
+	type revertBody struct {
+		Revision int64 `json:"revision" validate:"min=0"` // revision to go back to
+		Undo     bool  `json:"undo"`                      // go back to the revision before the current one
+	}
+	vars := mux.Vars(r) //get Parameter values as string
+	ref := docRef{Entity: vars["kind"], ID: vars["id"]}
+	if !validID(w, r, ref.ID, "document") {
+		return
+	}
+	var body revertBody
+	if !decodeBody(w, r, &body) || !validateBody(w, r, body) { // revert struct validation
+		return
+	}
+	if (body.Revision == 0) != body.Undo {
+		writeError(w, r, http.StatusUnprocessableEntity, codeValidation, "Validation error", fieldError{Field: "revision", Message: "give either revision or undo"})
+		return
+	}
+	version, ok := ifMatch(w, r)
+	if !ok {
+		return
+	}
+	var result versioned
+	err := h.store.Tx.Run(r.Context(), func(ctx context.Context) error {
+		var err error
+		result, err = revert(ctx, h.store, ref, body.Revision, version)
+		return err
+	})
+	if err != nil {
+		storeError(w, r, err)
+		return
+	}
+	writeVersioned(w, http.StatusOK, result)
+}
@@ -0,0 +1,142 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// renameVarient gives VAR1 each of names in turn, one revision per name.
+func renameVarient(t *testing.T, s Stores, names ...string) {
+	t.Helper()
+	for _, name := range names {
+		if _, err := s.Varients.Update(context.Background(), varientUpdate{"VAR1", name, name + " finish", "editor"}, anyVersion); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestRevert(t *testing.T) {
+	tests := []struct {
+		name     string
+		renames  []string
+		revision int64
+		version  int64
+		want     string // name after the revert
+		status   int    // of the requestError expected instead
+		err      error
+	}{
+		{name: "to the first revision", renames: []string{"Blue", "Red"}, revision: 1, want: "Black"},
+		{name: "to a middle revision", renames: []string{"Blue", "Red"}, revision: 2, want: "Blue"},
+		{name: "undo", renames: []string{"Blue", "Red"}, want: "Blue"},
+		{name: "at the current version", renames: []string{"Blue"}, revision: 1, version: 2, want: "Black"},
+		{name: "at another version", renames: []string{"Blue"}, revision: 1, version: 1, err: ErrVersionMismatch},
+		{name: "to the current revision", renames: []string{"Blue"}, revision: 2, status: http.StatusUnprocessableEntity},
+		{name: "to a later revision", renames: []string{"Blue"}, revision: 5, status: http.StatusUnprocessableEntity},
+		{name: "never changed", status: http.StatusUnprocessableEntity},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			s := newCatalog(t)
+			renameVarient(t, s, tt.renames...)
+			var result versioned
+			err := s.Tx.Run(ctx, func(ctx context.Context) error {
+				var err error
+				result, err = revert(ctx, s, docRef{"varient", "VAR1"}, tt.revision, tt.version)
+				return err
+			})
+			var reqErr requestError
+			switch {
+			case tt.status != 0:
+				if !errors.As(err, &reqErr) || reqErr.status != tt.status {
+					t.Fatalf("error %v, want status %d", err, tt.status)
+				}
+				return
+			case !errors.Is(err, tt.err):
+				t.Fatalf("error %v, want %v", err, tt.err)
+			case err != nil:
+				return
+			}
+			v := result.(varient)
+			if v.Vname != tt.want {
+				t.Errorf("reverted to %q, want %q", v.Vname, tt.want)
+			}
+			if current := int64(len(tt.renames)) + 2; v.Version != current {
+				t.Errorf("version %d, want %d", v.Version, current)
+			}
+			entries, err := s.Audit.Find(ctx, findQuery{Filter: bson.M{"id": "VAR1", "action": actionRevert}})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(entries) != 1 || entries[0].Revision != v.Version {
+				t.Fatalf("revert entries %+v", entries)
+			}
+			want := tt.revision
+			if want == 0 {
+				want = v.Version - 2 // the revision before the one undone
+			}
+			if entries[0].RevertedTo != want {
+				t.Errorf("revertedTo %d, want %d", entries[0].RevertedTo, want)
+			}
+		})
+	}
+}
+
+func TestRevertProductReferences(t *testing.T) {
+	ctx := context.Background()
+	s := newCatalog(t)
+	moved := productUpdate{"PRD1", "Phone X", "a product", 1, 10, 9, "editor", subprod{"CAT2", "SUB3", "BRD2", "VAR1"}}
+	if _, err := s.Products.Update(ctx, moved, anyVersion); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Brands.Delete(ctx, "BRD1", anyVersion); err != nil {
+		t.Fatal(err)
+	}
+	_, err := revert(ctx, s, docRef{"product", "PRD1"}, 1, anyVersion)
+	var reqErr requestError
+	if !errors.As(err, &reqErr) || reqErr.code != codeInvalidReference {
+		t.Fatalf("error %v, want %s", err, codeInvalidReference)
+	}
+	p, err := s.Products.Get(ctx, "PRD1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.SubProd.BrandId != "BRD2" || p.Version != 2 {
+		t.Errorf("PRD1 sold by %s at version %d, want left as it was", p.SubProd.BrandId, p.Version)
+	}
+}
+
+func TestRevertCategoryKeepsPlace(t *testing.T) {
+	ctx := context.Background()
+	s := newCatalog(t)
+	created, err := s.Categories.Get(ctx, "SUB4")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.SubCategories.Update(ctx, subcategoryUpdate{ScId: "SUB4", Scname: "Flip", Scdesc: "flip phones", Scmodifiedby: "editor"}, anyVersion); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Categories.Move(ctx, "SUB4", "", []string{}); err != nil {
+		t.Fatal(err)
+	}
+
+	// now at the top, SUB4 is a category, and no longer a subcategory
+	if _, err := revert(ctx, s, docRef{"subcategory", "SUB4"}, 1, anyVersion); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("as a subcategory: %v, want ErrNotFound", err)
+	}
+	result, err := revert(ctx, s, docRef{"category", "SUB4"}, 1, anyVersion)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := result.(category)
+	if c.Cname != "Foldable" || c.CParent != "" || len(c.CPath) != 0 {
+		t.Errorf("reverted to %q under %q %v, want Foldable at the top", c.Cname, c.CParent, c.CPath)
+	}
+	if c.Version != 4 || !c.CreatedAt.Equal(created.CreatedAt) {
+		t.Errorf("version %d, created %v, want 4 and %v", c.Version, c.CreatedAt, created.CreatedAt)
+	}
+}
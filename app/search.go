@@ -0,0 +1,482 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"html"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+// searchDoc is the searchable text of one product, with the names of its
+// category and brand copied in so a search for "nike" finds Nike products.
+type searchDoc struct {
+	PId       string    `json:"pid"`
+	Pname     string    `json:"pname"`
+	Pdesc     string    `json:"pdesc"`
+	Cname     string    `json:"cname"`
+	Bname     string    `json:"bname"`
+	Pstatus   bool      `json:"pstatus"`
+	Terms     []string  `json:"terms"`                      // distinct words of the fields above, for prefix lookups
+	UpdatedAt time.Time `json:"updatedAt" bson:"updatedAt"` // of the product, to tell when the document is stale
+}
+
+// searchQuery asks a SearchIndex for one page of matches.
+type searchQuery struct {
+	Terms  []string
+	Status *bool // only products with this status, when set
+	Skip   int64
+	Limit  int64
+}
+
+// scoredDoc is a search document with its score for a query.
+type scoredDoc struct {
+	searchDoc `bson:",inline"`
+	Score     float64 `json:"score"`
+}
+
+// searchFields are the fields a search looks at, with the weight of a match in each.
+var searchFields = []struct {
+	name   string
+	weight float64
+	text   func(searchDoc) string
+}{
+	{"pname", 4, func(d searchDoc) string { return d.Pname }},
+	{"cname", 2, func(d searchDoc) string { return d.Cname }},
+	{"bname", 2, func(d searchDoc) string { return d.Bname }},
+	{"pdesc", 1, func(d searchDoc) string { return d.Pdesc }},
+}
+
+// searchHit is one search result.
+type searchHit struct {
+	Product    product           `json:"product"`
+	Score      float64           `json:"score"`
+	Highlights map[string]string `json:"highlights"` // matched fields, matches wrapped in <em>
+}
+
+// words splits text into lower-case words of letters and digits.
+func words(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// newSearchDoc builds the search document of a product.
+func newSearchDoc(p product, cname, bname string) searchDoc {
+	doc := searchDoc{PId: p.PId, Pname: p.Pname, Pdesc: p.Pdesc, Cname: cname, Bname: bname, Pstatus: p.Pstatus, UpdatedAt: p.UpdatedAt}
+	seen := map[string]bool{}
+	for _, f := range searchFields {
+		for _, w := range words(f.text(doc)) {
+			if !seen[w] {
+				seen[w] = true
+				doc.Terms = append(doc.Terms, w)
+			}
+		}
+	}
+	return doc
+}
+
+// stem strips an English plural ending, so that "shoes" and "shoe", or
+// "batteries" and "battery", match each other.
+func stem(word string) string {
+	switch {
+	case len(word) > 4 && strings.HasSuffix(word, "ies"):
+		return word[:len(word)-3] + "y"
+	case len(word) > 4 && strings.HasSuffix(word, "sses"):
+		return word[:len(word)-2]
+	case len(word) > 3 && strings.HasSuffix(word, "s") && !strings.HasSuffix(word, "ss") && !strings.HasSuffix(word, "us") && !strings.HasSuffix(word, "is"):
+		return word[:len(word)-1]
+	}
+	return word
+}
+
+// lookupTerms returns the terms and their stems, the word starts a document
+// must have to match the query.
+func lookupTerms(terms []string) []string {
+	found := append([]string{}, terms...)
+	for _, term := range terms {
+		if s := stem(term); s != term && !contains(found, s) {
+			found = append(found, s)
+		}
+	}
+	return found
+}
+
+// queryTerms returns the distinct words of a search query.
+func queryTerms(q string) []string {
+	var terms []string
+	seen := map[string]bool{}
+	for _, w := range words(q) {
+		if !seen[w] {
+			seen[w] = true
+			terms = append(terms, w)
+		}
+	}
+	return terms
+}
+
+// matchTerm rates how well term matches word: 1 for the whole word, 0.75
+// for another form of it, 0.5 for a prefix. The Mongo index weighs its
+// matches alike through the field weights of its text index.
+func matchTerm(term, word string) float64 {
+	switch {
+	case word == term:
+		return 1
+	case stem(word) == stem(term):
+		return 0.75
+	case strings.HasPrefix(word, term):
+		return 0.5
+	}
+	return 0
+}
+
+// scoreDoc ranks a document against the query terms. Each term adds its best
+// match in every field times the field weight; the total is then scaled by
+// the share of terms that matched, so documents matching every term come first.
+func scoreDoc(doc searchDoc, terms []string) float64 {
+	var score float64
+	matched := 0
+	for _, term := range terms {
+		termScore := 0.0
+		for _, f := range searchFields {
+			best := 0.0
+			for _, w := range words(f.text(doc)) {
+				if m := matchTerm(term, w); m > best {
+					best = m
+				}
+			}
+			termScore += best * f.weight
+		}
+		if termScore > 0 {
+			matched++
+		}
+		score += termScore
+	}
+	return score * float64(matched) / float64(len(terms))
+}
+
+// highlight wraps the part of every word of text matched by a term in <em>
+// tags, escaping the rest as HTML. It reports whether anything matched.
+func highlight(text string, terms []string) (string, bool) {
+	var b strings.Builder
+	found := false
+	rest := text
+	for rest != "" {
+		// copy everything up to the next word as is
+		i := strings.IndexFunc(rest, func(r rune) bool { return unicode.IsLetter(r) || unicode.IsDigit(r) })
+		if i < 0 {
+			b.WriteString(html.EscapeString(rest))
+			break
+		}
+		b.WriteString(html.EscapeString(rest[:i]))
+		rest = rest[i:]
+		j := strings.IndexFunc(rest, func(r rune) bool { return !unicode.IsLetter(r) && !unicode.IsDigit(r) })
+		if j < 0 {
+			j = len(rest)
+		}
+		word := rest[:j]
+		rest = rest[j:]
+
+		// the longest term that starts the word, counted in runes
+		lower := strings.ToLower(word)
+		n := 0
+		for _, term := range terms {
+			if c := utf8.RuneCountInString(term); c > n && strings.HasPrefix(lower, term) {
+				n = c
+			}
+		}
+		if n == 0 {
+			b.WriteString(html.EscapeString(word))
+			continue
+		}
+		found = true
+		cut := len(word)
+		for k := range word {
+			if n == 0 {
+				cut = k
+				break
+			}
+			n--
+		}
+		b.WriteString("<em>" + html.EscapeString(word[:cut]) + "</em>" + html.EscapeString(word[cut:]))
+	}
+	return b.String(), found
+}
+
+//Search Products
+
+func (h *Handler) SearchProduct(w http.ResponseWriter, r *http.Request) {
+	params := r.URL.Query()
+	terms := queryTerms(params.Get("q"))
+	var problems []fieldError
+	if len(terms) == 0 {
+		problems = append(problems, fieldError{Field: "q", Message: "q must contain at least one word"})
+	}
+	limit, offset := int64(defaultPageSize), int64(0)
+	if v := params.Get("limit"); v != "" {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || n < 1 || n > maxPageSize {
+			problems = append(problems, fieldError{Field: "limit", Message: "limit must be a number from 1 to " + strconv.Itoa(maxPageSize)})
+		}
+		limit = n
+	}
+	if v := params.Get("offset"); v != "" {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || n < 0 {
+			problems = append(problems, fieldError{Field: "offset", Message: "offset must be a number from 0"})
+		}
+		offset = n
+	}
+	var status *bool
+	if v := params.Get("status"); v != "" {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			problems = append(problems, fieldError{Field: "status", Message: "status must be true or false"})
+		}
+		status = &b
+	}
+	if len(problems) > 0 {
+		writeError(w, r, http.StatusBadRequest, codeBadRequest, "Invalid query parameters", problems...)
+		return
+	}
+
+	ctx := r.Context()
+	q := searchQuery{Terms: terms, Status: status, Skip: offset, Limit: limit}
+	matches, err := h.store.Search.Search(ctx, q)
+	if err != nil {
+		storeError(w, r, err)
+		return
+	}
+	total, err := h.store.Search.Matches(ctx, q)
+	if err != nil {
+		storeError(w, r, err)
+		return
+	}
+
+	result := page[searchHit]{Data: []searchHit{}, Total: total, Limit: limit, Offset: offset}
+	marked := lookupTerms(terms)
+	for _, m := range matches {
+		prod, err := h.store.Products.Get(ctx, m.PId)
+		if errors.Is(err, ErrNotFound) {
+			continue // deleted since it was indexed
+		} else if err != nil {
+			storeError(w, r, err)
+			return
+		}
+		hit := searchHit{Product: prod, Score: m.Score, Highlights: map[string]string{}}
+		for _, f := range searchFields {
+			if text, ok := highlight(f.text(m.searchDoc), marked); ok {
+				hit.Highlights[f.name] = text
+			}
+		}
+		result.Data = append(result.Data, hit)
+	}
+	if offset+limit < result.Total {
+		result.Next = pageLink(r, "offset", strconv.FormatInt(offset+limit, 10))
+	}
+	if offset > 0 {
+		prev := offset - limit
+		if prev < 0 {
+			prev = 0
+		}
+		result.Prev = pageLink(r, "offset", strconv.FormatInt(prev, 10))
+	}
+	writeJSON(w, http.StatusOK, result)
+}
+
+// searchIndexer keeps the search index in step with the stores it wraps.
+type searchIndexer struct {
+	index      SearchIndex
+	products   ProductStore
+	categories CategoryStore
+	brands     BrandStore
+}
+
+// put indexes a product under the current names of its category and brand.
+func (x searchIndexer) put(ctx context.Context, p product) error {
+	var cname, bname string
+	if c, err := x.categories.Get(ctx, p.SubProd.CategoryId); err == nil {
+		cname = c.Cname
+	} else if !errors.Is(err, ErrNotFound) {
+		return err
+	}
+	if b, err := x.brands.Get(ctx, p.SubProd.BrandId); err == nil {
+		bname = b.Bname
+	} else if !errors.Is(err, ErrNotFound) {
+		return err
+	}
+	return x.index.Put(ctx, newSearchDoc(p, cname, bname))
+}
+
+// reindex indexes again the products whose field holds id, after the
+// category or brand they name changed.
+func (x searchIndexer) reindex(ctx context.Context, field, id string) error {
+	pids, err := x.products.Referencing(ctx, field, id)
+	if err != nil {
+		return err
+	}
+	for _, pid := range pids {
+		p, err := x.products.Get(ctx, pid)
+		if err != nil {
+			return err
+		}
+		if err := x.put(ctx, p); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// rebuildSearch brings the index in line with the products: it indexes the
+// products missing from it or changed since, and removes the documents of
+// products that are gone, e.g. after writes by another version of the app.
+func rebuildSearch(ctx context.Context, s Stores) error {
+	x := searchIndexer{index: s.Search, products: s.Products, categories: s.Categories, brands: s.Brands}
+	all, err := x.products.GetAll(ctx)
+	if err != nil {
+		return err
+	}
+	indexed, err := x.index.Indexed(ctx)
+	if err != nil {
+		return err
+	}
+	var stale []product
+	for _, p := range all {
+		if at, ok := indexed[p.PId]; !ok || !at.Equal(p.UpdatedAt) {
+			stale = append(stale, p)
+		}
+		delete(indexed, p.PId)
+	}
+	if len(stale) > 0 || len(indexed) > 0 {
+		logInfof("rebuilding the search index: %d products to index, %d to remove", len(stale), len(indexed))
+	}
+	for _, p := range stale {
+		if err := x.put(ctx, p); err != nil {
+			return err
+		}
+	}
+	for pid := range indexed {
+		if err := x.index.Remove(ctx, pid); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// withSearch wraps the product, category and brand stores so that every write
+// through them also updates the given search index.
+func withSearch(s Stores, index SearchIndex) Stores {
+	x := searchIndexer{index: index, products: s.Products, categories: s.Categories, brands: s.Brands}
+	s.Search = index
+	s.Products = indexedProductStore{s.Products, x}
+	s.Categories = indexedCategoryStore{s.Categories, x}
+	s.Brands = indexedBrandStore{s.Brands, x}
+	return s
+}
+
+type indexedProductStore struct {
+	ProductStore
+	x searchIndexer
+}
+
+func (s indexedProductStore) Insert(ctx context.Context, prod product) (interface{}, error) {
+	id, err := s.ProductStore.Insert(ctx, prod)
+	if err != nil {
+		return id, err
+	}
+	return id, s.x.put(ctx, prod)
+}
+
+func (s indexedProductStore) Update(ctx context.Context, body productUpdate, version int64) (product, error) {
+	p, err := s.ProductStore.Update(ctx, body, version)
+	if err != nil {
+		return p, err
+	}
+	return p, s.x.put(ctx, p)
+}
+
+func (s indexedProductStore) UpdateStatus(ctx context.Context, pid string, status bool, version int64) (product, error) {
+	p, err := s.ProductStore.UpdateStatus(ctx, pid, status, version)
+	if err != nil {
+		return p, err
+	}
+	return p, s.x.put(ctx, p)
+}
+
+func (s indexedProductStore) Restore(ctx context.Context, prod product, version int64) (product, error) {
+	p, err := s.ProductStore.Restore(ctx, prod, version)
+	if err != nil {
+		return p, err
+	}
+	return p, s.x.put(ctx, p)
+}
+
+func (s indexedProductStore) Delete(ctx context.Context, pid string, version int64) (int64, error) {
+	n, err := s.ProductStore.Delete(ctx, pid, version)
+	if err != nil || n == 0 {
+		return n, err
+	}
+	return n, s.x.index.Remove(ctx, pid)
+}
+
+type indexedCategoryStore struct {
+	CategoryStore
+	x searchIndexer
+}
+
+func (s indexedCategoryStore) Update(ctx context.Context, body categoryUpdate, version int64) (category, error) {
+	c, err := s.CategoryStore.Update(ctx, body, version)
+	if err != nil {
+		return c, err
+	}
+	return c, s.x.reindex(ctx, "subprod.categoryid", c.CId)
+}
+
+func (s indexedCategoryStore) Restore(ctx context.Context, cat category, version int64) (category, error) {
+	c, err := s.CategoryStore.Restore(ctx, cat, version)
+	if err != nil {
+		return c, err
+	}
+	return c, s.x.reindex(ctx, "subprod.categoryid", c.CId)
+}
+
+func (s indexedCategoryStore) Delete(ctx context.Context, cid string, version int64) (int64, error) {
+	n, err := s.CategoryStore.Delete(ctx, cid, version)
+	if err != nil || n == 0 {
+		return n, err
+	}
+	return n, s.x.reindex(ctx, "subprod.categoryid", cid)
+}
+
+type indexedBrandStore struct {
+	BrandStore
+	x searchIndexer
+}
+
+func (s indexedBrandStore) Update(ctx context.Context, body brandUpdate, version int64) (brand, error) {
+	b, err := s.BrandStore.Update(ctx, body, version)
+	if err != nil {
+		return b, err
+	}
+	return b, s.x.reindex(ctx, "subprod.brandid", b.BId)
+}
+
+func (s indexedBrandStore) Restore(ctx context.Context, br brand, version int64) (brand, error) {
+	b, err := s.BrandStore.Restore(ctx, br, version)
+	if err != nil {
+		return b, err
+	}
+	return b, s.x.reindex(ctx, "subprod.brandid", b.BId)
+}
+
+func (s indexedBrandStore) Delete(ctx context.Context, bid string, version int64) (int64, error) {
+	n, err := s.BrandStore.Delete(ctx, bid, version)
+	if err != nil || n == 0 {
+		return n, err
+	}
+	return n, s.x.reindex(ctx, "subprod.brandid", bid)
+}
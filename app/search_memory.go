@@ -0,0 +1,133 @@
+package app
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// memSearch is the in-process SearchIndex: an inverted index from each word
+// to the products containing it, plus the sorted word list for prefix lookups.
+type memSearch struct {
+	mu       sync.RWMutex
+	docs     map[string]searchDoc
+	postings map[string]map[string]bool // word -> pids
+	words    []string                   // sorted keys of postings
+}
+
+func newMemSearch() *memSearch {
+	return &memSearch{docs: map[string]searchDoc{}, postings: map[string]map[string]bool{}}
+}
+
+// Put and Remove take effect when the unit of work in ctx commits, as the
+// index lives outside the store it follows.
+func (m *memSearch) Put(ctx context.Context, doc searchDoc) error {
+	afterCommit(ctx, func() { m.put(doc) })
+	return nil
+}
+
+func (m *memSearch) put(doc searchDoc) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.remove(doc.PId)
+	m.docs[doc.PId] = doc
+	for _, w := range doc.Terms {
+		pids, ok := m.postings[w]
+		if !ok {
+			pids = map[string]bool{}
+			m.postings[w] = pids
+			i := sort.SearchStrings(m.words, w)
+			m.words = append(m.words, "")
+			copy(m.words[i+1:], m.words[i:])
+			m.words[i] = w
+		}
+		pids[doc.PId] = true
+	}
+}
+
+func (m *memSearch) Remove(ctx context.Context, pid string) error {
+	afterCommit(ctx, func() {
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		m.remove(pid)
+	})
+	return nil
+}
+
+// remove drops a product from the index. Callers must hold m.mu.
+func (m *memSearch) remove(pid string) {
+	doc, ok := m.docs[pid]
+	if !ok {
+		return
+	}
+	delete(m.docs, pid)
+	for _, w := range doc.Terms {
+		delete(m.postings[w], pid)
+		if len(m.postings[w]) == 0 {
+			delete(m.postings, w)
+			i := sort.SearchStrings(m.words, w)
+			m.words = append(m.words[:i], m.words[i+1:]...)
+		}
+	}
+}
+
+// matches scores every document with a word starting with a term or its
+// stem, and returns those that match, most relevant first.
+func (m *memSearch) matches(q searchQuery) []scoredDoc {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	found := map[string]bool{}
+	for _, term := range lookupTerms(q.Terms) {
+		// words starting with term sit together in the sorted list
+		for i := sort.SearchStrings(m.words, term); i < len(m.words) && strings.HasPrefix(m.words[i], term); i++ {
+			for pid := range m.postings[m.words[i]] {
+				found[pid] = true
+			}
+		}
+	}
+	scored := []scoredDoc{}
+	for pid := range found {
+		doc := m.docs[pid]
+		if q.Status != nil && doc.Pstatus != *q.Status {
+			continue
+		}
+		if score := scoreDoc(doc, q.Terms); score > 0 {
+			scored = append(scored, scoredDoc{doc, score})
+		}
+	}
+	sort.Slice(scored, func(i, j int) bool {
+		if scored[i].Score != scored[j].Score {
+			return scored[i].Score > scored[j].Score
+		}
+		return scored[i].PId < scored[j].PId
+	})
+	return scored
+}
+
+func (m *memSearch) Search(ctx context.Context, q searchQuery) ([]scoredDoc, error) {
+	scored := m.matches(q)
+	if q.Skip >= int64(len(scored)) {
+		return []scoredDoc{}, nil
+	}
+	scored = scored[q.Skip:]
+	if q.Limit > 0 && q.Limit < int64(len(scored)) {
+		scored = scored[:q.Limit]
+	}
+	return scored, nil
+}
+
+func (m *memSearch) Matches(ctx context.Context, q searchQuery) (int64, error) {
+	return int64(len(m.matches(q))), nil
+}
+
+func (m *memSearch) Indexed(ctx context.Context) (map[string]time.Time, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	indexed := map[string]time.Time{}
+	for pid, doc := range m.docs {
+		indexed[pid] = doc.UpdatedAt
+	}
+	return indexed, nil
+}
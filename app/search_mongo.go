@@ -0,0 +1,129 @@
+package app
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// mongoSearch is the Mongo SearchIndex: one document per product in its own
+// collection, under a text index on the search fields. Mongo matches whole
+// words and their stems and ranks them by textScore.
+type mongoSearch struct {
+	coll *mongo.Collection
+}
+
+// searchSchema marks the form of the stored search documents. Raise it when
+// that form changes, and rebuildSearch builds every document again.
+const searchSchema = 1
+
+// mongoSearchDoc is a search document as stored, with the starts of its words
+// spelled out, as a text index only matches whole words.
+type mongoSearchDoc struct {
+	searchDoc `bson:",inline"`
+	Prefixes  string `bson:"prefixes"`
+	Schema    int    `bson:"schema"`
+}
+
+// prefixes returns the starts of the words of doc, from two letters up to one
+// short of the word, separated by spaces.
+func prefixes(doc searchDoc) string {
+	var found []string
+	seen := map[string]bool{}
+	for _, w := range doc.Terms {
+		runes := []rune(w)
+		for n := 2; n < len(runes); n++ {
+			if p := string(runes[:n]); !seen[p] {
+				seen[p] = true
+				found = append(found, p)
+			}
+		}
+	}
+	return strings.Join(found, " ")
+}
+
+// ensureIndexes creates the indexes the search relies on; it is a no-op when they exist.
+// Field weights are doubled from searchFields so a prefix counts half a whole word.
+func (m mongoSearch) ensureIndexes(ctx context.Context) error {
+	text := bson.D{}
+	weights := bson.D{}
+	for _, f := range searchFields {
+		text = append(text, bson.E{Key: f.name, Value: "text"})
+		weights = append(weights, bson.E{Key: f.name, Value: int32(2 * f.weight)})
+	}
+	text = append(text, bson.E{Key: "prefixes", Value: "text"})
+	weights = append(weights, bson.E{Key: "prefixes", Value: int32(1)})
+	_, err := m.coll.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "pid", Value: 1}}, Options: options.Index().SetUnique(true)},
+		{Keys: text, Options: options.Index().SetName("search_text").SetWeights(weights).SetDefaultLanguage("english")},
+	})
+	return err
+}
+
+func (m mongoSearch) Put(ctx context.Context, doc searchDoc) error {
+	stored := mongoSearchDoc{doc, prefixes(doc), searchSchema}
+	_, err := m.coll.ReplaceOne(ctx, bson.M{"pid": doc.PId}, stored, options.Replace().SetUpsert(true))
+	return err
+}
+
+func (m mongoSearch) Remove(ctx context.Context, pid string) error {
+	_, err := m.coll.DeleteOne(ctx, bson.M{"pid": pid})
+	return err
+}
+
+// textFilter selects the documents matching any of the query terms.
+func textFilter(q searchQuery) bson.M {
+	filter := bson.M{"$text": bson.M{"$search": strings.Join(q.Terms, " ")}}
+	if q.Status != nil {
+		filter["pstatus"] = *q.Status
+	}
+	return filter
+}
+
+func (m mongoSearch) Search(ctx context.Context, q searchQuery) ([]scoredDoc, error) {
+	score := bson.M{"$meta": "textScore"}
+	opts := options.Find().
+		SetProjection(bson.M{"score": score, "prefixes": 0}).
+		SetSort(bson.D{{Key: "score", Value: score}, {Key: "pid", Value: 1}}).
+		SetSkip(q.Skip).
+		SetLimit(q.Limit)
+	cur, err := m.coll.Find(ctx, textFilter(q), opts)
+	if err != nil {
+		return nil, err
+	}
+	docs := []scoredDoc{}
+	err = cur.All(ctx, &docs)
+	return docs, err
+}
+
+func (m mongoSearch) Matches(ctx context.Context, q searchQuery) (int64, error) {
+	return m.coll.CountDocuments(ctx, textFilter(q))
+}
+
+func (m mongoSearch) Indexed(ctx context.Context) (map[string]time.Time, error) {
+	cur, err := m.coll.Find(ctx, bson.M{}, options.Find().SetProjection(bson.M{"pid": 1, "updatedAt": 1, "schema": 1}))
+	if err != nil {
+		return nil, err
+	}
+	var docs []struct {
+		PId       string    `bson:"pid"`
+		UpdatedAt time.Time `bson:"updatedAt"`
+		Schema    int       `bson:"schema"`
+	}
+	if err := cur.All(ctx, &docs); err != nil {
+		return nil, err
+	}
+	indexed := map[string]time.Time{}
+	for _, d := range docs {
+		if d.Schema == searchSchema {
+			indexed[d.PId] = d.UpdatedAt
+		} else {
+			indexed[d.PId] = time.Time{}
+		}
+	}
+	return indexed, nil
+}
@@ -0,0 +1,53 @@
+package app
+
+import (
+	"context"
+	"sort"
+	"testing"
+	"time"
+)
+
+func TestRebuildSearch(t *testing.T) {
+	ctx := context.Background()
+	s := newCatalog(t)
+	// PRD1 indexed as it was an hour ago, PRD2 not at all, and a product
+	// that is gone still indexed
+	p, err := s.Products.Get(ctx, "PRD1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	old := newSearchDoc(p, "", "")
+	old.Pname = "Old name"
+	old.UpdatedAt = p.UpdatedAt.Add(-time.Hour)
+	for _, err := range []error{
+		s.Search.Put(ctx, old),
+		s.Search.Remove(ctx, "PRD2"),
+		s.Search.Put(ctx, searchDoc{PId: "GONE", Pname: "Gone"}),
+	} {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := rebuildSearch(ctx, s); err != nil {
+		t.Fatal(err)
+	}
+	indexed, err := s.Search.Indexed(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var pids []string
+	for pid := range indexed {
+		pids = append(pids, pid)
+	}
+	sort.Strings(pids)
+	if want := []string{"PRD1", "PRD2", "PRD3"}; !equalPaths(pids, want) {
+		t.Fatalf("indexed %v, want %v", pids, want)
+	}
+	if !indexed["PRD1"].Equal(p.UpdatedAt) {
+		t.Errorf("PRD1 indexed as of %v, want %v", indexed["PRD1"], p.UpdatedAt)
+	}
+	if n, err := s.Search.Matches(ctx, searchQuery{Terms: []string{"old"}}); err != nil || n != 0 {
+		t.Errorf("%d matches for the old name (%v), want none", n, err)
+	}
+}
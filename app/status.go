@@ -0,0 +1,275 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// statusRecord remembers the statuses a cascading deactivation overwrote,
+// so that reactivating the same document can put them back.
+type statusRecord struct {
+	Key      string        `json:"key"` // entity:id of the deactivated document
+	Entity   string        `json:"entity"`
+	ID       string        `json:"id"`
+	Previous []statusEntry `json:"previous"`
+	At       time.Time     `json:"at"`
+}
+
+// statusEntry is the status one descendant had before the cascade.
+type statusEntry struct {
+	Entity string `json:"entity"`
+	ID     string `json:"id"`
+	Status bool   `json:"status"`
+}
+
+func statusKey(ref docRef) string {
+	return ref.Entity + ":" + ref.ID
+}
+
+// descendants returns every document with a status below ref in the catalog
+// hierarchy, following the same relations as the delete policies. Brands
+// are shared between subcategories, so they are reached through their
+// assignments and only switched off, along with their products, when no
+// subcategory they are assigned to stays active.
+func descendants(ctx context.Context, s Stores, ref docRef) ([]docRef, error) {
+	var found []docRef
+	seen := map[docRef]bool{ref: true}
+	var brands []string // reached through an assignment
+	walk := func(queue []docRef) error {
+		for len(queue) > 0 {
+			parent := queue[0]
+			queue = queue[1:]
+			for _, rel := range dependents[parent.Entity] {
+				if rel.child == "assignment" {
+					if parent.Entity == "brand" {
+						continue // its own assignments
+					}
+					assignments, err := s.BrandAssignments.Find(ctx, findQuery{Filter: bson.M{rel.field: parent.ID}})
+					if err != nil {
+						return err
+					}
+					for _, a := range assignments {
+						brands = append(brands, a.BId)
+					}
+					continue
+				}
+				ids, err := entityStore(s, rel.child).Referencing(ctx, rel.field, parent.ID)
+				if err != nil {
+					return err
+				}
+				for _, id := range ids {
+					child := docRef{rel.child, id}
+					if !seen[child] {
+						seen[child] = true
+						found = append(found, child)
+						queue = append(queue, child)
+					}
+				}
+			}
+		}
+		return nil
+	}
+	if err := walk([]docRef{ref}); err != nil {
+		return nil, err
+	}
+
+	var off []docRef
+	for _, bid := range brands {
+		b := docRef{"brand", bid}
+		if seen[b] {
+			continue
+		}
+		active, err := activelyAssigned(ctx, s, bid, seen)
+		if err != nil {
+			return nil, err
+		}
+		if !active {
+			seen[b] = true
+			found = append(found, b)
+			off = append(off, b)
+		}
+	}
+	if err := walk(off); err != nil {
+		return nil, err
+	}
+	return found, nil
+}
+
+// activelyAssigned reports whether the brand is assigned to an active
+// subcategory other than those being switched off.
+func activelyAssigned(ctx context.Context, s Stores, bid string, switchedOff map[docRef]bool) (bool, error) {
+	assignments, err := s.BrandAssignments.Find(ctx, findQuery{Filter: bson.M{"bid": bid}})
+	if err != nil {
+		return false, err
+	}
+	for _, a := range assignments {
+		if switchedOff[docRef{"subcategory", a.ScId}] || switchedOff[docRef{"category", a.ScId}] {
+			continue
+		}
+		node, err := s.Categories.Get(ctx, a.ScId)
+		if errors.Is(err, ErrNotFound) {
+			continue
+		} else if err != nil {
+			return false, err
+		}
+		if node.Cstatus {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// deactivateTree switches off every descendant of ref and records the
+// statuses it overwrote. Running it again while a record exists keeps the
+// statuses saved the first time and only adds descendants that are new since.
+func deactivateTree(ctx context.Context, s Stores, ref docRef) (int, error) {
+	rec, err := s.StatusRecords.Get(ctx, statusKey(ref))
+	if errors.Is(err, ErrNotFound) {
+		rec = statusRecord{Key: statusKey(ref), Entity: ref.Entity, ID: ref.ID}
+	} else if err != nil {
+		return 0, err
+	}
+	recorded := map[docRef]bool{}
+	for _, e := range rec.Previous {
+		recorded[docRef{e.Entity, e.ID}] = true
+	}
+
+	refs, err := descendants(ctx, s, ref)
+	if err != nil {
+		return 0, err
+	}
+	for _, d := range refs {
+		if recorded[d] {
+			continue
+		}
+		status, err := statusOf(ctx, s, d)
+		if err != nil {
+			return 0, err
+		}
+		rec.Previous = append(rec.Previous, statusEntry{d.Entity, d.ID, status})
+	}
+	rec.At = time.Now().UTC()
+	// save first, so a failure part way through can still be reversed
+	if err := s.StatusRecords.Save(ctx, rec); err != nil {
+		return 0, err
+	}
+	for _, d := range refs {
+		if err := setStatus(ctx, s, d, false); err != nil {
+			return 0, err
+		}
+	}
+	return len(refs), nil
+}
+
+// restoreTree puts back the statuses recorded when ref was deactivated with
+// cascade. Documents deleted since are skipped; without a record it does nothing.
+func restoreTree(ctx context.Context, s Stores, ref docRef) (int, error) {
+	rec, err := s.StatusRecords.Get(ctx, statusKey(ref))
+	if errors.Is(err, ErrNotFound) {
+		return 0, nil
+	} else if err != nil {
+		return 0, err
+	}
+	restored := 0
+	for _, e := range rec.Previous {
+		err := setStatus(ctx, s, docRef{e.Entity, e.ID}, e.Status)
+		if errors.Is(err, ErrNotFound) {
+			continue
+		} else if err != nil {
+			return restored, err
+		}
+		restored++
+	}
+	_, err = s.StatusRecords.Delete(ctx, rec.Key)
+	return restored, err
+}
+
+func statusOf(ctx context.Context, s Stores, ref docRef) (bool, error) {
+	switch ref.Entity {
+	case "product":
+		v, err := s.Products.Get(ctx, ref.ID)
+		return v.Pstatus, err
+	case "category":
+		v, err := s.Categories.Get(ctx, ref.ID)
+		return v.Cstatus, err
+	case "subcategory":
+		v, err := s.SubCategories.Get(ctx, ref.ID)
+		return v.Scstatus, err
+	case "brand":
+		v, err := s.Brands.Get(ctx, ref.ID)
+		return v.Bstatus, err
+	default:
+		v, err := s.Varients.Get(ctx, ref.ID)
+		return v.Vstatus, err
+	}
+}
+
+func setStatus(ctx context.Context, s Stores, ref docRef, status bool) error {
+	var err error
+	switch ref.Entity {
+	case "product":
+		_, err = s.Products.UpdateStatus(ctx, ref.ID, status, anyVersion)
+	case "category":
+		_, err = s.Categories.UpdateStatus(ctx, ref.ID, status, anyVersion)
+	case "subcategory":
+		_, err = s.SubCategories.UpdateStatus(ctx, ref.ID, status, anyVersion)
+	case "brand":
+		_, err = s.Brands.UpdateStatus(ctx, ref.ID, status, anyVersion)
+	case "varient":
+		_, err = s.Varients.UpdateStatus(ctx, ref.ID, status, anyVersion)
+	}
+	return err
+}
+
+// updateStatus serves the status endpoints of documents with descendants.
+// update sets the status of the document itself, at the version If-Match
+// names. With ?cascade=true, deactivating also switches the whole subtree
+// off and reactivating restores what the subtree had before, in the same
+// unit of work.
+func (h *Handler) updateStatus(w http.ResponseWriter, r *http.Request, entity, id string, status bool, update func(ctx context.Context, version int64) (versioned, error)) {
+	version, ok := ifMatch(w, r)
+	if !ok {
+		return
+	}
+	cascade := false
+	if param := r.URL.Query().Get("cascade"); param != "" {
+		var err error
+		if cascade, err = strconv.ParseBool(param); err != nil {
+			writeError(w, r, http.StatusBadRequest, codeBadRequest, "cascade must be true or false")
+			return
+		}
+	}
+	var result versioned
+	err := h.store.Tx.Run(r.Context(), func(ctx context.Context) error {
+		if cascade {
+			ref := docRef{entity, id}
+			if err := requireVersion(ctx, h.store, ref, version); err != nil {
+				return err
+			}
+			var n int
+			var err error
+			if status {
+				n, err = restoreTree(ctx, h.store, ref)
+			} else {
+				n, err = deactivateTree(ctx, h.store, ref)
+			}
+			if err != nil {
+				return err
+			}
+			logInfof("%s %s status %v cascaded to %v documents", entity, id, status, n)
+		}
+		var err error
+		result, err = update(ctx, version)
+		return err
+	})
+	if err != nil {
+		storeError(w, r, err)
+		return
+	}
+	writeVersioned(w, http.StatusOK, result)
+}
@@ -0,0 +1,106 @@
+package app
+
+import (
+	"context"
+	"sort"
+	"testing"
+)
+
+func TestDescendants(t *testing.T) {
+	tests := []struct {
+		name string
+		off  []string // subcategories switched off beforehand
+		ref  docRef
+		want []string
+	}{
+		{
+			name: "category with a brand sold only below it",
+			ref:  docRef{"category", "CAT1"},
+			want: []string{"brand:BRD1", "product:PRD1", "product:PRD3", "subcategory:SUB1", "subcategory:SUB2", "subcategory:SUB4"},
+		},
+		{
+			name: "brand still sold elsewhere",
+			ref:  docRef{"subcategory", "SUB3"},
+			want: []string{"product:PRD2"},
+		},
+		{
+			name: "brand sold elsewhere, but not actively",
+			off:  []string{"SUB1"},
+			ref:  docRef{"category", "CAT2"},
+			want: []string{"brand:BRD2", "product:PRD2", "product:PRD3", "subcategory:SUB3"},
+		},
+		{
+			name: "leaf subcategory",
+			ref:  docRef{"subcategory", "SUB4"},
+			want: []string{},
+		},
+		{
+			name: "brand",
+			ref:  docRef{"brand", "BRD2"},
+			want: []string{"product:PRD2", "product:PRD3"},
+		},
+		{
+			name: "varient",
+			ref:  docRef{"varient", "VAR1"},
+			want: []string{"product:PRD1", "product:PRD2", "product:PRD3"},
+		},
+		{
+			name: "product",
+			ref:  docRef{"product", "PRD1"},
+			want: []string{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			s := newCatalog(t)
+			for _, scid := range tt.off {
+				if _, err := s.SubCategories.UpdateStatus(ctx, scid, false, anyVersion); err != nil {
+					t.Fatal(err)
+				}
+			}
+			found, err := descendants(ctx, s, tt.ref)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := refs(found)
+			sort.Strings(got)
+			if !equalPaths(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDeactivateTreeRestores(t *testing.T) {
+	ctx := context.Background()
+	s := newCatalog(t)
+	if _, err := s.Products.UpdateStatus(ctx, "PRD3", false, anyVersion); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := deactivateTree(ctx, s, docRef{"category", "CAT1"}); err != nil {
+		t.Fatal(err)
+	}
+	b, err := s.Brands.Get(ctx, "BRD1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b.Bstatus {
+		t.Error("BRD1 is still active")
+	}
+	if _, err := restoreTree(ctx, s, docRef{"category", "CAT1"}); err != nil {
+		t.Fatal(err)
+	}
+	for id, want := range map[string]bool{"PRD1": true, "PRD3": false} {
+		p, err := s.Products.Get(ctx, id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if p.Pstatus != want {
+			t.Errorf("%s status %v, want %v as before", id, p.Pstatus, want)
+		}
+	}
+	if b, _ = s.Brands.Get(ctx, "BRD1"); !b.Bstatus {
+		t.Error("BRD1 was not reactivated")
+	}
+}
@@ -0,0 +1,177 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// ErrNotFound is returned by a store when no document matches the given ID.
+var ErrNotFound = errors.New("no data found")
+
+// ErrDuplicate is returned by a store when a document with the same ID already exists.
+var ErrDuplicate = errors.New("duplicate document")
+
+// ErrVersionMismatch is returned by a store when a write names a version
+// and the document has moved on from it.
+var ErrVersionMismatch = errors.New("version mismatch")
+
+// anyVersion makes a write regardless of the version of the document.
+// Every other version passed to Update, UpdateStatus, Restore or Delete is
+// checked in the same operation as the write.
+const anyVersion int64 = 0
+
+// ProductStore is the persistence boundary used by the product handlers.
+type ProductStore interface {
+	Exists(ctx context.Context, pid string) (bool, error)
+	Insert(ctx context.Context, prod product) (interface{}, error)
+	Get(ctx context.Context, pid string) (product, error)
+	GetAll(ctx context.Context) ([]product, error)
+	Find(ctx context.Context, q findQuery) ([]product, error)
+	Count(ctx context.Context, filter bson.M) (int64, error)
+	Facets(ctx context.Context, specs []facetSpec) (map[string][]facetCount, error)
+	Referencing(ctx context.Context, field, id string) ([]string, error)
+	Update(ctx context.Context, body productUpdate, version int64) (product, error)
+	UpdateStatus(ctx context.Context, pid string, status bool, version int64) (product, error)
+	// Restore writes back every field an editor can change, including the
+	// references, from an earlier copy of the product, in a single write.
+	Restore(ctx context.Context, p product, version int64) (product, error)
+	Delete(ctx context.Context, pid string, version int64) (int64, error)
+}
+
+// CategoryStore is the persistence boundary used by the category handlers.
+type CategoryStore interface {
+	Exists(ctx context.Context, cid string) (bool, error)
+	Insert(ctx context.Context, cat category) (interface{}, error)
+	Get(ctx context.Context, cid string) (category, error)
+	GetAll(ctx context.Context) ([]category, error)
+	Find(ctx context.Context, q findQuery) ([]category, error)
+	Count(ctx context.Context, filter bson.M) (int64, error)
+	Referencing(ctx context.Context, field, id string) ([]string, error)
+	Update(ctx context.Context, body categoryUpdate, version int64) (category, error)
+	UpdateStatus(ctx context.Context, cid string, status bool, version int64) (category, error)
+	// Restore writes back every field an editor can change from an earlier
+	// copy of the category, in a single write. The place in the tree, cparent
+	// and cpath, is left as it is: only Move changes it.
+	Restore(ctx context.Context, c category, version int64) (category, error)
+	Move(ctx context.Context, cid, parent string, path []string) (category, error)
+	Delete(ctx context.Context, cid string, version int64) (int64, error)
+}
+
+// SubCategoryStore is the persistence boundary used by the subcategory
+// handlers. Subcategories are the categories that have a parent.
+type SubCategoryStore interface {
+	Exists(ctx context.Context, scid string) (bool, error)
+	Insert(ctx context.Context, subc subcategory) (interface{}, error)
+	Get(ctx context.Context, scid string) (subcategory, error)
+	GetAll(ctx context.Context) ([]subcategory, error)
+	Find(ctx context.Context, q findQuery) ([]subcategory, error)
+	Count(ctx context.Context, filter bson.M) (int64, error)
+	Referencing(ctx context.Context, field, id string) ([]string, error)
+	Update(ctx context.Context, body subcategoryUpdate, version int64) (subcategory, error)
+	UpdateStatus(ctx context.Context, scid string, status bool, version int64) (subcategory, error)
+	Delete(ctx context.Context, scid string, version int64) (int64, error)
+}
+
+// BrandStore is the persistence boundary used by the brand handlers.
+type BrandStore interface {
+	Exists(ctx context.Context, bid string) (bool, error)
+	Insert(ctx context.Context, b brand) (interface{}, error)
+	Get(ctx context.Context, bid string) (brand, error)
+	GetAll(ctx context.Context) ([]brand, error)
+	Find(ctx context.Context, q findQuery) ([]brand, error)
+	Count(ctx context.Context, filter bson.M) (int64, error)
+	Referencing(ctx context.Context, field, id string) ([]string, error)
+	Update(ctx context.Context, body brandUpdate, version int64) (brand, error)
+	UpdateStatus(ctx context.Context, bid string, status bool, version int64) (brand, error)
+	// Restore writes back every field an editor can change from an earlier
+	// copy of the brand, in a single write. Its assignments are not touched.
+	Restore(ctx context.Context, b brand, version int64) (brand, error)
+	Delete(ctx context.Context, bid string, version int64) (int64, error)
+}
+
+// BrandAssignmentStore records which subcategories each brand is sold in,
+// one document per pair, keyed by assignmentKey.
+type BrandAssignmentStore interface {
+	Exists(ctx context.Context, key string) (bool, error)
+	Insert(ctx context.Context, a brandAssignment) (interface{}, error)
+	Find(ctx context.Context, q findQuery) ([]brandAssignment, error)
+	Referencing(ctx context.Context, field, id string) ([]string, error)
+	Delete(ctx context.Context, key string, version int64) (int64, error)
+}
+
+// VarientStore is the persistence boundary used by the varient handlers.
+type VarientStore interface {
+	Exists(ctx context.Context, vid string) (bool, error)
+	Insert(ctx context.Context, v varient) (interface{}, error)
+	Get(ctx context.Context, vid string) (varient, error)
+	GetAll(ctx context.Context) ([]varient, error)
+	Find(ctx context.Context, q findQuery) ([]varient, error)
+	Count(ctx context.Context, filter bson.M) (int64, error)
+	Referencing(ctx context.Context, field, id string) ([]string, error)
+	Update(ctx context.Context, body varientUpdate, version int64) (varient, error)
+	UpdateStatus(ctx context.Context, vid string, status bool, version int64) (varient, error)
+	// Restore writes back every field an editor can change from an earlier
+	// copy of the varient, in a single write.
+	Restore(ctx context.Context, v varient, version int64) (varient, error)
+	Delete(ctx context.Context, vid string, version int64) (int64, error)
+}
+
+// StatusRecordStore keeps the statuses overwritten by cascading
+// deactivations, keyed by the document that was deactivated.
+type StatusRecordStore interface {
+	Get(ctx context.Context, key string) (statusRecord, error)
+	Save(ctx context.Context, rec statusRecord) error
+	Delete(ctx context.Context, key string) (int64, error)
+}
+
+// AuditStore is the append-only log of writes to catalog documents.
+type AuditStore interface {
+	Insert(ctx context.Context, e auditEntry) error
+	Find(ctx context.Context, q findQuery) ([]auditEntry, error)
+	Count(ctx context.Context, filter bson.M) (int64, error)
+}
+
+// CounterStore issues sequence numbers, one sequence per name, each number
+// at most once even under concurrent requests.
+type CounterStore interface {
+	Next(ctx context.Context, name string) (int64, error)
+}
+
+// UnitOfWork runs fn so that its writes, across every store, become visible
+// together or not at all. fn must make its store calls with the context it
+// is given, and may run more than once when the backend retries it.
+type UnitOfWork interface {
+	Run(ctx context.Context, fn func(ctx context.Context) error) error
+}
+
+// SearchIndex holds the searchable text of every product. Search ranks every
+// document matching the query and returns one page of them, most relevant
+// first; Matches counts them all. Indexed maps the pid of every document to
+// the update time of the product it was built from, or the zero time when it
+// was built in an older form.
+type SearchIndex interface {
+	Put(ctx context.Context, doc searchDoc) error
+	Remove(ctx context.Context, pid string) error
+	Search(ctx context.Context, q searchQuery) ([]scoredDoc, error)
+	Matches(ctx context.Context, q searchQuery) (int64, error)
+	Indexed(ctx context.Context) (map[string]time.Time, error)
+}
+
+// Stores groups the per-entity stores the handlers depend on.
+type Stores struct {
+	Products         ProductStore
+	Categories       CategoryStore
+	SubCategories    SubCategoryStore
+	Brands           BrandStore
+	BrandAssignments BrandAssignmentStore
+	Varients         VarientStore
+	StatusRecords    StatusRecordStore
+	Counters         CounterStore
+	Audit            AuditStore
+	Tx               UnitOfWork
+	Search           SearchIndex
+	suggestions      *suggester // kept in process for either backend
+}
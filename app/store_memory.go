@@ -0,0 +1,497 @@
+package app
+
+import (
+	"context"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// NewMemoryStores returns an in-process implementation of every store. Data
+// lives only as long as the process, which makes it suitable for tests and
+// for running the API without a MongoDB server.
+func NewMemoryStores() Stores {
+	db := &memoryDB{colls: map[string]*memTable{}, unique: map[string][]uniqueIndex{
+		"Product":         uniqueIndexes["product"],
+		"Category":        uniqueIndexes["category"],
+		"Brand":           uniqueIndexes["brand"],
+		"BrandAssignment": uniqueIndexes["brandAssignment"],
+		"Varient":         uniqueIndexes["varient"],
+		"StatusRecord":    uniqueIndexes["statusRecord"],
+		"Counter":         uniqueIndexes["counter"],
+		"Audit":           uniqueIndexes["audit"],
+	}}
+	stores := Stores{
+		Products:         productStore{memCollection[product]{db, "Product", "pid"}},
+		Categories:       categoryStore{memCollection[category]{db, "Category", "cid"}},
+		SubCategories:    subCategoryStore{memCollection[category]{db, "Category", "cid"}},
+		Brands:           brandStore{memCollection[brand]{db, "Brand", "bid"}},
+		BrandAssignments: brandAssignmentStore{memCollection[brandAssignment]{db, "BrandAssignment", "key"}},
+		Varients:         varientStore{memCollection[varient]{db, "Varient", "vid"}},
+		StatusRecords:    statusRecordStore{memCollection[statusRecord]{db, "StatusRecord", "key"}},
+		Counters:         counterStore{memCollection[counter]{db, "Counter", "name"}},
+		Audit:            auditStore{memCollection[auditEntry]{db, "Audit", "key"}},
+		Tx:               memTx{db},
+	}
+	return withSuggestions(withSearch(withAudit(stores), newMemSearch()), newSuggester())
+}
+
+// memoryDB keeps every collection as BSON-shaped documents, so field names
+// and update semantics match what the Mongo store persists.
+type memoryDB struct {
+	mu     sync.RWMutex
+	colls  map[string]*memTable
+	unique map[string][]uniqueIndex // by collection, as the Mongo store creates them
+}
+
+// memTable is one collection: documents by business key plus insertion order.
+type memTable struct {
+	docs  map[string]bson.M
+	order []string
+}
+
+// table returns the named collection, creating it on first use. Callers must hold db.mu.
+func (db *memoryDB) table(name string) *memTable {
+	t, ok := db.colls[name]
+	if !ok {
+		t = &memTable{docs: map[string]bson.M{}}
+		db.colls[name] = t
+	}
+	return t
+}
+
+// conflict returns the unique index that doc, stored under id, would break
+// in the named collection. Callers must hold db.mu.
+func (db *memoryDB) conflict(name, id string, doc bson.M) (uniqueIndex, bool) {
+	t := db.table(name)
+	for _, idx := range db.unique[name] {
+		want := indexValues(idx, doc)
+		for other, d := range t.docs {
+			if other != id && reflect.DeepEqual(indexValues(idx, d), want) {
+				return idx, true
+			}
+		}
+	}
+	return uniqueIndex{}, false
+}
+
+// indexValues returns the values doc has for the fields of idx, nil where a field is missing.
+func indexValues(idx uniqueIndex, doc bson.M) []interface{} {
+	values := make([]interface{}, len(idx.fields))
+	for i, f := range idx.fields {
+		v, _ := lookup(doc, f)
+		if s, ok := v.(string); ok && idx.fold {
+			v = strings.ToLower(s)
+		}
+		values[i] = v
+	}
+	return values
+}
+
+// toDoc converts a value to its BSON document form.
+func toDoc(v interface{}) (bson.M, error) {
+	raw, err := bson.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	var doc bson.M
+	err = bson.Unmarshal(raw, &doc)
+	return doc, err
+}
+
+// fromDoc decodes a BSON document into a typed value.
+func fromDoc[T any](doc bson.M) (T, error) {
+	var result T
+	raw, err := bson.Marshal(doc)
+	if err != nil {
+		return result, err
+	}
+	err = bson.Unmarshal(raw, &result)
+	return result, err
+}
+
+// lookup returns the value at a dotted path such as "subprod.brandid".
+func lookup(doc bson.M, path string) (interface{}, bool) {
+	var v interface{} = doc
+	for _, name := range strings.Split(path, ".") {
+		m, ok := v.(bson.M)
+		if !ok {
+			return nil, false
+		}
+		if v, ok = m[name]; !ok {
+			return nil, false
+		}
+	}
+	return v, true
+}
+
+// matches reports whether doc satisfies every condition of filter: a field
+// equal to a value, within $gt/$gte/$lt/$lte bounds or among $in values. As in
+// Mongo, an array field satisfies a condition when one of its elements does,
+// values of different numeric types compare by value, bounds only match
+// values of their own type and a missing field equals null.
+func matches(doc, filter bson.M) bool {
+	for path, want := range filter {
+		got, _ := lookup(doc, path)
+		if satisfies(got, want) {
+			continue
+		}
+		found := false
+		if elems, isArray := got.(bson.A); isArray {
+			for _, e := range elems {
+				if found = satisfies(e, want); found {
+					break
+				}
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// satisfies reports whether one value meets one filter condition.
+func satisfies(got, want interface{}) bool {
+	bounds, isRange := want.(bson.M)
+	if !isRange {
+		return equalValues(got, want)
+	}
+	for op, bound := range bounds {
+		if op == "$in" {
+			if !inValues(got, bound) {
+				return false
+			}
+			continue
+		}
+		if rankOf(got) != rankOf(bound) {
+			return false // no type bracketing across types, as in Mongo
+		}
+		c := compareValues(got, bound)
+		if op == "$gt" && c <= 0 || op == "$gte" && c < 0 || op == "$lt" && c >= 0 || op == "$lte" && c > 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// inValues reports whether v equals one of the values of list, a bson.A or []string.
+func inValues(v, list interface{}) bool {
+	switch l := list.(type) {
+	case bson.A:
+		for _, x := range l {
+			if equalValues(v, x) {
+				return true
+			}
+		}
+	case []string:
+		for _, x := range l {
+			if equalValues(v, x) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// equalValues reports whether two BSON values are equal. Arrays and
+// embedded documents, which compareValues does not order, are compared whole.
+func equalValues(a, b interface{}) bool {
+	if rankOf(a) == 4 && rankOf(b) == 4 {
+		return reflect.DeepEqual(a, b)
+	}
+	return compareValues(a, b) == 0
+}
+
+// compareValues orders two BSON values the way Mongo sorts them: by type
+// first (null, numbers, strings, booleans, dates), then by value.
+func compareValues(a, b interface{}) int {
+	ra, va := sortable(a)
+	rb, vb := sortable(b)
+	if ra != rb {
+		return ra - rb
+	}
+	switch x := va.(type) {
+	case float64:
+		return cmpOrdered(x, vb.(float64))
+	case string:
+		return cmpOrdered(x, vb.(string))
+	case bool:
+		if x == vb.(bool) {
+			return 0
+		} else if x {
+			return 1
+		}
+		return -1
+	case time.Time:
+		y := vb.(time.Time)
+		switch {
+		case x.Before(y):
+			return -1
+		case x.After(y):
+			return 1
+		}
+	}
+	return 0
+}
+
+// sortable returns the type rank of a BSON value and the value in a comparable form.
+func sortable(v interface{}) (int, interface{}) {
+	switch x := v.(type) {
+	case nil:
+		return 0, nil
+	case int32:
+		return 1, float64(x)
+	case int64:
+		return 1, float64(x)
+	case int:
+		return 1, float64(x)
+	case float32:
+		return 1, float64(x)
+	case float64:
+		return 1, x
+	case string:
+		return 2, x
+	case bool:
+		return 5, x
+	case primitive.DateTime:
+		return 6, x.Time()
+	case time.Time:
+		return 6, x
+	}
+	return 4, nil
+}
+
+func rankOf(v interface{}) int {
+	rank, _ := sortable(v)
+	return rank
+}
+
+func cmpOrdered[V float64 | string](a, b V) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+// memCollection is the in-memory counterpart of mongoCollection.
+type memCollection[T any] struct {
+	db   *memoryDB
+	name string
+	key  string
+}
+
+func (c memCollection[T]) exists(ctx context.Context, id string) (bool, error) {
+	defer c.db.read(ctx)()
+	_, ok := c.db.table(c.name).docs[id]
+	return ok, nil
+}
+
+func (c memCollection[T]) insert(ctx context.Context, v T) (interface{}, error) {
+	doc, err := toDoc(v)
+	if err != nil {
+		return nil, err
+	}
+	id, _ := doc[c.key].(string)
+	defer c.db.write(ctx, c.name)()
+	t := c.db.table(c.name)
+	if _, ok := t.docs[id]; ok {
+		return nil, duplicateKeyError{keyIndex(c.key)}
+	}
+	if idx, ok := c.db.conflict(c.name, id, doc); ok {
+		return nil, duplicateKeyError{idx}
+	}
+	objectID := primitive.NewObjectID()
+	doc["_id"] = objectID
+	t.docs[id] = doc
+	t.order = append(t.order, id)
+	return objectID, nil
+}
+
+func (c memCollection[T]) get(ctx context.Context, id string) (T, error) {
+	defer c.db.read(ctx)()
+	doc, ok := c.db.table(c.name).docs[id]
+	if !ok {
+		var zero T
+		return zero, ErrNotFound
+	}
+	return fromDoc[T](doc)
+}
+
+func (c memCollection[T]) getAll(ctx context.Context) ([]T, error) {
+	defer c.db.read(ctx)()
+	t := c.db.table(c.name)
+	results := make([]T, 0, len(t.order))
+	for _, id := range t.order {
+		v, err := fromDoc[T](t.docs[id])
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, v)
+	}
+	return results, nil
+}
+
+func (c memCollection[T]) find(ctx context.Context, q findQuery) ([]T, error) {
+	field := q.Sort
+	if field == "" {
+		field = c.key
+	}
+	filter := q.Filter
+	unlock := c.db.read(ctx)
+	t := c.db.table(c.name)
+	var docs []bson.M
+	for _, id := range t.order {
+		if matches(t.docs[id], filter) {
+			docs = append(docs, t.docs[id])
+		}
+	}
+	unlock()
+
+	pos := func(doc bson.M) position {
+		v, _ := lookup(doc, field)
+		k, _ := doc[c.key].(string)
+		return position{v, k}
+	}
+	less := func(a, b position) bool {
+		cmp := compareValues(a.Value, b.Value)
+		if cmp == 0 {
+			cmp = strings.Compare(a.Key, b.Key)
+		}
+		if q.Desc {
+			return cmp > 0
+		}
+		return cmp < 0
+	}
+	sort.SliceStable(docs, func(i, j int) bool { return less(pos(docs[i]), pos(docs[j])) })
+	if q.After != nil {
+		after := *q.After
+		i := sort.Search(len(docs), func(i int) bool { return less(after, pos(docs[i])) })
+		docs = docs[i:]
+	}
+	if q.Skip >= int64(len(docs)) {
+		docs = nil
+	} else {
+		docs = docs[q.Skip:]
+	}
+	if q.Limit > 0 && int64(len(docs)) > q.Limit {
+		docs = docs[:q.Limit]
+	}
+
+	results := make([]T, 0, len(docs))
+	for _, doc := range docs {
+		v, err := fromDoc[T](doc)
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, v)
+	}
+	return results, nil
+}
+
+func (c memCollection[T]) count(ctx context.Context, filter bson.M) (int64, error) {
+	defer c.db.read(ctx)()
+	t := c.db.table(c.name)
+	var n int64
+	for _, id := range t.order {
+		if matches(t.docs[id], filter) {
+			n++
+		}
+	}
+	return n, nil
+}
+
+func (c memCollection[T]) facets(ctx context.Context, specs []facetSpec) (map[string][]facetCount, error) {
+	defer c.db.read(ctx)()
+	t := c.db.table(c.name)
+	docs := make([]bson.M, 0, len(t.order))
+	for _, id := range t.order {
+		docs = append(docs, t.docs[id])
+	}
+	return facetCounts(docs, specs), nil
+}
+
+func (c memCollection[T]) keys(ctx context.Context, filter bson.M) ([]string, error) {
+	defer c.db.read(ctx)()
+	t := c.db.table(c.name)
+	ids := []string{}
+	for _, id := range t.order {
+		if matches(t.docs[id], filter) {
+			ids = append(ids, id)
+		}
+	}
+	return ids, nil
+}
+
+// set applies the given field values and returns the updated document.
+func (c memCollection[T]) set(ctx context.Context, id string, version int64, fields bson.M) (T, error) {
+	var zero T
+	values, err := toDoc(fields) // nested structs become documents, as in Mongo
+	if err != nil {
+		return zero, err
+	}
+	defer c.db.write(ctx, c.name)()
+	doc, ok := c.db.table(c.name).docs[id]
+	if !ok {
+		return zero, ErrNotFound
+	}
+	current, _ := doc["version"].(int64)
+	if version != anyVersion && current != version {
+		return zero, ErrVersionMismatch
+	}
+	updated := bson.M{}
+	for k, v := range doc {
+		updated[k] = v
+	}
+	for k, v := range values {
+		updated[k] = v
+	}
+	updated["version"] = current + 1
+	if idx, ok := c.db.conflict(c.name, id, updated); ok {
+		return zero, duplicateKeyError{idx}
+	}
+	c.db.table(c.name).docs[id] = updated
+	return fromDoc[T](updated)
+}
+
+func (c memCollection[T]) increment(ctx context.Context, id, field string) (T, error) {
+	defer c.db.write(ctx, c.name)()
+	t := c.db.table(c.name)
+	doc, ok := t.docs[id]
+	if !ok {
+		doc = bson.M{"_id": primitive.NewObjectID(), c.key: id}
+		t.docs[id] = doc
+		t.order = append(t.order, id)
+	}
+	n, _ := doc[field].(int64)
+	doc[field] = n + 1
+	return fromDoc[T](doc)
+}
+
+func (c memCollection[T]) delete(ctx context.Context, id string, version int64) (int64, error) {
+	defer c.db.write(ctx, c.name)()
+	t := c.db.table(c.name)
+	doc, ok := t.docs[id]
+	if !ok {
+		return 0, nil
+	}
+	if current, _ := doc["version"].(int64); version != anyVersion && current != version {
+		return 0, ErrVersionMismatch
+	}
+	delete(t.docs, id)
+	for i, k := range t.order {
+		if k == id {
+			t.order = append(t.order[:i], t.order[i+1:]...)
+			break
+		}
+	}
+	return 1, nil
+}
@@ -0,0 +1,131 @@
+package app
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestMatches(t *testing.T) {
+	at := time.Date(2024, 1, 31, 9, 0, 0, 0, time.UTC)
+	doc := bson.M{
+		"pid":     "PRD1",
+		"pqty":    int32(3),
+		"pprice":  9.5,
+		"pstatus": true,
+		"cpath":   bson.A{"CAT1", "SUB1"},
+		"empty":   bson.A{},
+		"subprod": bson.M{"brandid": "BRD1"},
+		"at":      primitive.NewDateTimeFromTime(at),
+	}
+	tests := []struct {
+		name   string
+		filter bson.M
+		want   bool
+	}{
+		{"empty filter", bson.M{}, true},
+		{"equal string", bson.M{"pid": "PRD1"}, true},
+		{"other string", bson.M{"pid": "PRD2"}, false},
+		{"every condition", bson.M{"pid": "PRD1", "pstatus": false}, false},
+		{"dotted path", bson.M{"subprod.brandid": "BRD1"}, true},
+		{"dotted path through a scalar", bson.M{"pid.x": "PRD1"}, false},
+		{"int32 equals float64", bson.M{"pqty": 3.0}, true},
+		{"int64 equals int32", bson.M{"pqty": int64(3)}, true},
+		{"number is not a string", bson.M{"pqty": "3"}, false},
+		{"bool", bson.M{"pstatus": true}, true},
+		{"array element", bson.M{"cpath": "SUB1"}, true},
+		{"no array element", bson.M{"cpath": "SUB2"}, false},
+		{"whole array", bson.M{"cpath": bson.A{"CAT1", "SUB1"}}, true},
+		{"array in another order", bson.M{"cpath": bson.A{"SUB1", "CAT1"}}, false},
+		{"empty array is not null", bson.M{"empty": nil}, false},
+		{"missing field equals null", bson.M{"cparent": nil}, true},
+		{"missing field is not empty", bson.M{"cparent": ""}, false},
+		{"gte and lte", bson.M{"pprice": bson.M{"$gte": 9, "$lte": 10}}, true},
+		{"gte bound included", bson.M{"pprice": bson.M{"$gte": 9.5}}, true},
+		{"gt bound excluded", bson.M{"pprice": bson.M{"$gt": 9.5}}, false},
+		{"lt", bson.M{"pprice": bson.M{"$lt": int64(10)}}, true},
+		{"bound of another type", bson.M{"pid": bson.M{"$gt": 1}}, false},
+		{"string bound on a number", bson.M{"pqty": bson.M{"$lt": "z"}}, false},
+		{"bound on a missing field", bson.M{"cparent": bson.M{"$gt": ""}}, false},
+		{"string gt empty", bson.M{"pid": bson.M{"$gt": ""}}, true},
+		{"in bson.A", bson.M{"pid": bson.M{"$in": bson.A{"PRD2", "PRD1"}}}, true},
+		{"in []string", bson.M{"pid": bson.M{"$in": []string{"PRD2", "PRD3"}}}, false},
+		{"in with numbers", bson.M{"pqty": bson.M{"$in": bson.A{1, 3.0}}}, true},
+		{"in on an array field", bson.M{"cpath": bson.M{"$in": []string{"SUB1"}}}, true},
+		{"in null on a missing field", bson.M{"cparent": bson.M{"$in": bson.A{nil}}}, true},
+		{"empty in", bson.M{"pid": bson.M{"$in": bson.A{}}}, false},
+		{"time since", bson.M{"at": bson.M{"$gte": at}}, true},
+		{"time until", bson.M{"at": bson.M{"$lte": at.Add(-time.Second)}}, false},
+		{"time bound on a string", bson.M{"pid": bson.M{"$gte": at}}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := matches(doc, tt.filter); got != tt.want {
+				t.Errorf("matches(%v) = %v, want %v", tt.filter, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSatisfies(t *testing.T) {
+	tests := []struct {
+		name      string
+		got, want interface{}
+		ok        bool
+	}{
+		{"null equals null", nil, nil, true},
+		{"null is not zero", nil, 0, false},
+		{"float32 equals float64", float32(1.5), 1.5, true},
+		{"int equals int64", 7, int64(7), true},
+		{"false is not zero", false, 0, false},
+		{"range on a bool", true, bson.M{"$gt": false}, true},
+		{"range on mixed numbers", int32(5), bson.M{"$gt": 4.5, "$lt": int64(6)}, true},
+		{"range on null", nil, bson.M{"$lt": 1}, false},
+		{"in and range together", 5, bson.M{"$in": bson.A{5, 6}, "$lt": 6}, true},
+		{"in and range apart", 6, bson.M{"$in": bson.A{5, 6}, "$lt": 6}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if ok := satisfies(tt.got, tt.want); ok != tt.ok {
+				t.Errorf("satisfies(%v, %v) = %v, want %v", tt.got, tt.want, ok, tt.ok)
+			}
+		})
+	}
+}
+
+func TestMemoryFind(t *testing.T) {
+	ctx := context.Background()
+	s := newCatalog(t)
+	tests := []struct {
+		name string
+		q    findQuery
+		want []string
+	}{
+		{"by key", findQuery{Filter: bson.M{}}, []string{"PRD1", "PRD2", "PRD3"}},
+		{"descending", findQuery{Filter: bson.M{}, Desc: true}, []string{"PRD3", "PRD2", "PRD1"}},
+		{"by name", findQuery{Filter: bson.M{}, Sort: "pname"}, []string{"PRD1", "PRD3", "PRD2"}},
+		{"filtered", findQuery{Filter: bson.M{"subprod.brandid": "BRD2"}}, []string{"PRD2", "PRD3"}},
+		{"skip and limit", findQuery{Filter: bson.M{}, Skip: 1, Limit: 1}, []string{"PRD2"}},
+		{"after", findQuery{Filter: bson.M{}, Sort: "pname", After: &position{"Phone X", "PRD1"}}, []string{"PRD3", "PRD2"}},
+		{"after descending", findQuery{Filter: bson.M{}, Desc: true, After: &position{"PRD2", "PRD2"}}, []string{"PRD1"}},
+		{"after ties on the key", findQuery{Filter: bson.M{}, Sort: "pprice", After: &position{9.0, "PRD1"}}, []string{"PRD2", "PRD3"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			found, err := s.Products.Find(ctx, tt.q)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for _, p := range found {
+				got = append(got, p.PId)
+			}
+			if !equalPaths(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
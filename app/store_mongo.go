@@ -0,0 +1,227 @@
+package app
+
+import (
+	"context"
+	"math"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// NewMongoStores returns the MongoDB implementation of every store, backed by
+// the named collections of the given database. Units of work are
+// transactions when the deployment supports them.
+func NewMongoStores(db *mongo.Database, names CollectionNames, transactions bool) Stores {
+	stores := Stores{
+		Products:         productStore{mongoCollection[product]{db.Collection(names.Product), "pid"}},
+		Categories:       categoryStore{mongoCollection[category]{db.Collection(names.Category), "cid"}},
+		SubCategories:    subCategoryStore{mongoCollection[category]{db.Collection(names.Category), "cid"}},
+		Brands:           brandStore{mongoCollection[brand]{db.Collection(names.Brand), "bid"}},
+		BrandAssignments: brandAssignmentStore{mongoCollection[brandAssignment]{db.Collection(names.BrandAssignment), "key"}},
+		Varients:         varientStore{mongoCollection[varient]{db.Collection(names.Varient), "vid"}},
+		StatusRecords:    statusRecordStore{mongoCollection[statusRecord]{db.Collection(names.StatusRecord), "key"}},
+		Counters:         counterStore{mongoCollection[counter]{db.Collection(names.Counter), "name"}},
+		Audit:            auditStore{mongoCollection[auditEntry]{db.Collection(names.Audit), "key"}},
+		Tx:               mongoTx{db.Client(), transactions},
+	}
+	return withSuggestions(withSearch(withAudit(stores), mongoSearch{db.Collection(names.Search)}), newSuggester())
+}
+
+// mongoCollection holds the operations shared by every entity collection.
+// Documents are looked up by their business key (pid, cid, ...), not by _id.
+type mongoCollection[T any] struct {
+	coll *mongo.Collection
+	key  string
+}
+
+func (c mongoCollection[T]) exists(ctx context.Context, id string) (bool, error) {
+	count, err := c.coll.CountDocuments(ctx, bson.M{c.key: id})
+	return count > 0, err
+}
+
+func (c mongoCollection[T]) insert(ctx context.Context, doc T) (interface{}, error) {
+	insertResult, err := c.coll.InsertOne(ctx, doc)
+	if err != nil {
+		return nil, mongoWriteError(err)
+	}
+	return insertResult.InsertedID, nil
+}
+
+func (c mongoCollection[T]) get(ctx context.Context, id string) (T, error) {
+	var result T
+	err := c.coll.FindOne(ctx, bson.M{c.key: id}).Decode(&result)
+	if err == mongo.ErrNoDocuments {
+		return result, ErrNotFound
+	}
+	return result, err
+}
+
+func (c mongoCollection[T]) getAll(ctx context.Context) ([]T, error) {
+	cur, err := c.coll.Find(ctx, bson.M{}) //returns a *mongo.Cursor
+	if err != nil {
+		return nil, err
+	}
+	results := []T{}
+	err = cur.All(ctx, &results) // decodes every document and closes the cursor
+	return results, err
+}
+
+func (c mongoCollection[T]) find(ctx context.Context, q findQuery) ([]T, error) {
+	field := q.Sort
+	if field == "" {
+		field = c.key
+	}
+	filter := q.Filter
+	if q.After != nil {
+		filter = bson.M{"$and": bson.A{q.Filter, afterFilter(field, c.key, q.Desc, *q.After)}}
+	}
+	dir := 1
+	if q.Desc {
+		dir = -1
+	}
+	order := bson.D{{Key: field, Value: dir}}
+	if field != c.key {
+		order = append(order, bson.E{Key: c.key, Value: dir})
+	}
+	opts := options.Find().SetSort(order).SetSkip(q.Skip)
+	if q.Limit > 0 {
+		opts.SetLimit(q.Limit)
+	}
+	cur, err := c.coll.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	results := []T{}
+	err = cur.All(ctx, &results)
+	return results, err
+}
+
+func (c mongoCollection[T]) count(ctx context.Context, filter bson.M) (int64, error) {
+	return c.coll.CountDocuments(ctx, filter)
+}
+
+// facets runs every spec in one $facet aggregation.
+func (c mongoCollection[T]) facets(ctx context.Context, specs []facetSpec) (map[string][]facetCount, error) {
+	stages := bson.M{}
+	for _, spec := range specs {
+		var count bson.M
+		if spec.Bounds == nil {
+			count = bson.M{"$group": bson.M{"_id": "$" + spec.Field, "count": bson.M{"$sum": 1}}}
+		} else {
+			boundaries := bson.A{}
+			for _, b := range spec.Bounds {
+				boundaries = append(boundaries, b)
+			}
+			boundaries = append(boundaries, math.Inf(1))
+			count = bson.M{"$bucket": bson.M{"groupBy": "$" + spec.Field, "boundaries": boundaries, "default": "other", "output": bson.M{"count": bson.M{"$sum": 1}}}}
+		}
+		stages[spec.Name] = bson.A{bson.M{"$match": spec.Filter}, count, bson.M{"$sort": bson.M{"_id": 1}}}
+	}
+	cur, err := c.coll.Aggregate(ctx, bson.A{bson.M{"$facet": stages}})
+	if err != nil {
+		return nil, err
+	}
+	var out []map[string][]struct {
+		ID    interface{} `bson:"_id"`
+		Count int64       `bson:"count"`
+	}
+	if err := cur.All(ctx, &out); err != nil {
+		return nil, err
+	}
+	result := map[string][]facetCount{}
+	if len(out) == 0 {
+		return result, nil
+	}
+	for name, groups := range out[0] {
+		for _, g := range groups {
+			if g.ID == "other" {
+				continue // outside every bucket
+			}
+			result[name] = append(result[name], facetCount{g.ID, g.Count})
+		}
+	}
+	return result, nil
+}
+
+func (c mongoCollection[T]) keys(ctx context.Context, filter bson.M) ([]string, error) {
+	cur, err := c.coll.Find(ctx, filter, options.Find().SetProjection(bson.M{c.key: 1}))
+	if err != nil {
+		return nil, err
+	}
+	var docs []bson.M
+	if err := cur.All(ctx, &docs); err != nil {
+		return nil, err
+	}
+	ids := make([]string, 0, len(docs))
+	for _, doc := range docs {
+		id, _ := doc[c.key].(string)
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+// set applies the given field values and returns the updated document.
+func (c mongoCollection[T]) set(ctx context.Context, id string, version int64, fields bson.M) (T, error) {
+	var result T
+	after := options.After // for returning updated document
+	returnOpt := options.FindOneAndUpdateOptions{
+		ReturnDocument: &after,
+	}
+	update := bson.M{"$set": fields, "$inc": bson.M{"version": int64(1)}}
+	err := c.coll.FindOneAndUpdate(ctx, c.versionFilter(id, version), update, &returnOpt).Decode(&result)
+	if err == mongo.ErrNoDocuments {
+		return result, c.missing(ctx, id, version)
+	}
+	return result, mongoWriteError(err)
+}
+
+func (c mongoCollection[T]) increment(ctx context.Context, id, field string) (T, error) {
+	var result T
+	opts := options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After)
+	update := bson.M{"$inc": bson.M{field: int64(1)}}
+	err := c.coll.FindOneAndUpdate(ctx, bson.M{c.key: id}, update, opts).Decode(&result)
+	if mongo.IsDuplicateKeyError(err) {
+		// a concurrent upsert created the document first; it now exists
+		err = c.coll.FindOneAndUpdate(ctx, bson.M{c.key: id}, update, opts).Decode(&result)
+	}
+	return result, err
+}
+
+func (c mongoCollection[T]) delete(ctx context.Context, id string, version int64) (int64, error) {
+	opts := options.Delete().SetCollation(&options.Collation{}) // to specify language-specific rules for string comparison, such as rules for lettercase
+	res, err := c.coll.DeleteOne(ctx, c.versionFilter(id, version), opts)
+	if err != nil {
+		return 0, err
+	}
+	if res.DeletedCount == 0 && version != anyVersion {
+		if err := c.missing(ctx, id, version); err != ErrNotFound {
+			return 0, err
+		}
+	}
+	return res.DeletedCount, nil
+}
+
+// versionFilter matches the document with the given key, and version unless it is anyVersion.
+func (c mongoCollection[T]) versionFilter(id string, version int64) bson.M {
+	if version == anyVersion {
+		return bson.M{c.key: id}
+	}
+	return bson.M{c.key: id, "version": version}
+}
+
+// missing tells why a write matched no document: ErrVersionMismatch when the
+// document exists at another version, ErrNotFound when there is none.
+func (c mongoCollection[T]) missing(ctx context.Context, id string, version int64) error {
+	if version == anyVersion {
+		return ErrNotFound
+	}
+	exists, err := c.exists(ctx, id)
+	if err != nil {
+		return err
+	}
+	if exists {
+		return ErrVersionMismatch
+	}
+	return ErrNotFound
+}
@@ -2,247 +2,151 @@ package app
 
 import (
 	"context"
-	"encoding/json"
-	"fmt"
-	"log"
+	"errors"
 	"net/http"
-	"regexp"
+	"time"
 
-	"github.com/go-playground/validator/v10"
 	"github.com/gorilla/mux"
-	"go.mongodb.org/mongo-driver/bson"
-	"go.mongodb.org/mongo-driver/bson/primitive"
-	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
 // struct for storing data
 type subcategory struct {
-	ScId         string `json:"scid"`
-	CId          string `json:"cid"`
-	Scname       string `json:"scname"`
-	Scdesc       string `json:"scdesc"`
-	Sccreatedby  string `json:"sccreatedby"`
-	Scmodifiedby string `json:"scmodifiedby"`
-	Scstatus     bool   `json:"scstatus"`
+	ScId         string    `json:"scid" validate:"omitempty,alphanum,min=4,max=26"`
+	CId          string    `json:"cid" validate:"required,alphanum,min=4,max=26"`
+	Scname       string    `json:"scname" validate:"required,min=3,max=20"`
+	Scdesc       string    `json:"scdesc" validate:"required,min=5,max=100"`
+	Sccreatedby  string    `json:"sccreatedby" validate:"required,min=3,max=20"`
+	Scmodifiedby string    `json:"scmodifiedby" validate:"required,min=3,max=20"`
+	Scstatus     bool      `json:"scstatus"`
+	Version      int64     `json:"version"`                    // one more on every write; sent as the ETag
+	CreatedAt    time.Time `json:"createdAt" bson:"createdAt"` // set by the server
+	UpdatedAt    time.Time `json:"updatedAt" bson:"updatedAt"`
+	CreatedBy    string    `json:"createdBy,omitempty" bson:"createdBy,omitempty"` // the user named by the actor header, if any
+	UpdatedBy    string    `json:"updatedBy,omitempty" bson:"updatedBy,omitempty"`
 }
 
-var subCategoryCollection = db().Database("ProductApp").Collection("Subcategory") // get collection "users" from db() which returns *mongo.Client
+// struct for updating data
+type subcategoryUpdate struct {
+	ScId         string `json:"scid" validate:"required,alphanum,min=4,max=26"` // value that has to be matched
+	CId          string `json:"cid" validate:"required,alphanum,min=4,max=26"`  // value that has to be modified
+	Scname       string `json:"scname" validate:"required,min=3,max=20"`        // value that has to be modified
+	Scdesc       string `json:"scdesc" validate:"required,min=5,max=100"`       // value that has to be modified
+	Scmodifiedby string `json:"scmodifiedby" validate:"required,min=3,max=20"`  // value that has to be modified
+}
 
 // Create SubCategory
 
-func CreateSubCategory(w http.ResponseWriter, r *http.Request) {
-
-	w.Header().Set("Content-Type", "application/json") // for adding Content-type
-
+func (h *Handler) CreateSubCategory(w http.ResponseWriter, r *http.Request) {
 	var subc subcategory
-	err := json.NewDecoder(r.Body).Decode(&subc) // storing in person variable of type user
-	if err != nil {
-		fmt.Print(err)
-		w.WriteHeader(400)
+	if !decodeBody(w, r, &subc) || !validateBody(w, r, subc) { //create struct validation
+		return
 	}
-
-	ccount, errc := categoryCollection.CountDocuments(context.TODO(), bson.D{{"cid", subc.CId}})
-	scount, errs := subCategoryCollection.CountDocuments(context.TODO(), bson.D{{"scid", subc.ScId}})
-
-	if errc != nil || errs != nil {
-		log.Fatal(errc)
-		log.Fatal(errs)
-	} else {
-		if ccount == 0 {
-			// w.WriteHeader(204)
-			json.NewEncoder(w).Encode("Invalid Category Id")
-
-		} else if scount > 0 {
-			fmt.Print(scount)
-			json.NewEncoder(w).Encode("Duplicate Subcategory!")
-		} else if scount == 0 {
-			insertResult, err := subCategoryCollection.InsertOne(context.TODO(), subc)
-			if err != nil {
-				log.Fatal(err)
-				w.WriteHeader(500)
-			} else {
-				fmt.Println("Inserted a single document: ", insertResult)
-				json.NewEncoder(w).Encode(insertResult.InsertedID) // return the mongodb ID of generated document
-				w.WriteHeader(200)
-			}
-		} else {
-			json.NewEncoder(w).Encode("Invalid Subcategory!")
+	if !h.newID(w, r, subcategoryIDs, &subc.ScId, h.store.Categories.Exists) { // categories and subcategories share IDs
+		return
+	}
+	var created subcategory
+	err := h.store.Tx.Run(r.Context(), func(ctx context.Context) error {
+		_, err := h.store.SubCategories.Insert(ctx, subc) // reads the parent for the path
+		if errors.Is(err, ErrNotFound) {
+			return requestError{http.StatusUnprocessableEntity, codeInvalidReference, "Invalid Category Id", []fieldError{{Field: "cid", Message: "no such document"}}}
+		} else if err != nil {
+			return err
 		}
+		created, err = h.store.SubCategories.Get(ctx, subc.ScId) // as stored, with its version and stamps
+		return err
+	})
+	if err != nil {
+		storeError(w, r, err)
+		return
 	}
-
+	logInfof("inserted a single document: %v", created.ScId)
+	w.Header().Set("Location", "/api/GetSubCategory/"+created.ScId)
+	writeVersioned(w, http.StatusCreated, created) // return the created document with its ETag
 }
 
 // Get SubCategory
 
-func GetSubCategory(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Content-Type", "application/json")
+func (h *Handler) GetSubCategory(w http.ResponseWriter, r *http.Request) {
 	params := mux.Vars(r)["id"] //get Parameter value as string
-	is_alphanumeric := regexp.MustCompile(`^[a-zA-Z0-9]*$`).MatchString(params)
-
-	// var body subcategory
-	// e := json.NewDecoder(r.Body).Decode(&body)
-	// if e != nil {
-
-	// 	fmt.Print(e)
-	// }
-	if is_alphanumeric {
-		var result primitive.M //  an unordered representation of a BSON document which is a Map
-		filter := bson.M{"scid": params}
-		err := subCategoryCollection.FindOne(context.TODO(), filter).Decode(&result)
-		if err != nil {
-
-			fmt.Println(err)
-			json.NewEncoder(w).Encode("No data found!")
-
-		} else {
-			json.NewEncoder(w).Encode(result) // returns a Map containing document
-			w.WriteHeader(200)
-		}
-	} else {
-		json.NewEncoder(w).Encode("Please enter the correct SubCategory ID!")
-		w.WriteHeader(204)
+	if !validID(w, r, params, "SubCategory") {
+		return
 	}
-}
-
-// Get All SubCategory
-func GetAllSubCategory(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Content-Type", "application/json")
-	var results []primitive.M                                          //slice for multiple documents
-	cur, err := subCategoryCollection.Find(context.TODO(), bson.D{{}}) //returns a *mongo.Cursor
+	result, err := h.store.SubCategories.Get(r.Context(), params)
 	if err != nil {
-
-		fmt.Println(err)
-		w.WriteHeader(400)
-
+		storeError(w, r, err)
+		return
 	}
-	for cur.Next(context.TODO()) { //Next() gets the next document for corresponding cursor
+	writeVersioned(w, http.StatusOK, result) // returns the document with its ETag
+}
 
-		var elem primitive.M
-		err := cur.Decode(&elem)
-		if err != nil {
-			log.Fatal(err)
-			w.WriteHeader(500)
-		}
+// Get All SubCategory
 
-		results = append(results, elem) // appending document pointed by Next()
-	}
-	cur.Close(context.TODO()) // close the cursor once stream of documents has exhausted
-	json.NewEncoder(w).Encode(results)
-	w.WriteHeader(200)
+func (h *Handler) GetAllSubCategory(w http.ResponseWriter, r *http.Request) {
+	serveList[subcategory](w, r, h.store.SubCategories, "scid", subCategoryFilters, nil)
 }
 
 //Update SubCategory
 
-func UpdateSubCategory(w http.ResponseWriter, r *http.Request) {
-
-	w.Header().Set("Content-Type", "application/json")
-	validate := validator.New()
-
-	type updateBody struct {
-		ScId         string `json:"scid" validate:"required,alphanum,min=4,max=10"` // value that has to be matched
-		CId          string `json:"cid" validate:"required,alphanum,min=4,max=10"`  // value that has to be modified
-		Scname       string `json:"scname" validate:"required,min=3,max=20"`        // value that has to be modified
-		Scdesc       string `json:"scdesc" validate:"required,min=3,max=20"`        // value that has to be modified
-		Scmodifiedby string `json:"scmodifiedby" validate:"required,min=3,max=20"`  // value that has to be modified
+func (h *Handler) UpdateSubCategory(w http.ResponseWriter, r *http.Request) {
+	var body subcategoryUpdate
+	if !decodeBody(w, r, &body) || !validateBody(w, r, body) { // update struct validation
+		return
 	}
-	var body updateBody
-	e := json.NewDecoder(r.Body).Decode(&body)
-	if e != nil {
-
-		fmt.Print(e)
-		w.WriteHeader(400)
+	version, ok := ifMatch(w, r)
+	if !ok {
+		return
 	}
-	errv := validate.Struct(body) // update struct validation
-	if errv != nil {
-		fmt.Println(errv)
-		w.WriteHeader(401)
-		json.NewEncoder(w).Encode("Validation error")
-	} else {
-		filter := bson.D{{"scid", body.ScId}} // converting value to BSON type
-		after := options.After                // for returning updated document
-		returnOpt := options.FindOneAndUpdateOptions{
-
-			ReturnDocument: &after,
+	var result subcategory
+	err := h.store.Tx.Run(r.Context(), func(ctx context.Context) error {
+		current, err := h.store.SubCategories.Get(ctx, body.ScId)
+		if err != nil {
+			return err
 		}
-		update := bson.D{{"$set", bson.D{{"cid", body.CId}, {"scname", body.Scname}, {"scdesc", body.Scdesc}, {"scmodifiedby", body.Scmodifiedby}}}}
-		updateResult := subCategoryCollection.FindOneAndUpdate(context.TODO(), filter, update, &returnOpt)
-
-		var result primitive.M
-		_ = updateResult.Decode(&result)
-
-		json.NewEncoder(w).Encode(result)
-		w.WriteHeader(200)
+		if version != anyVersion && current.Version != version {
+			return ErrVersionMismatch
+		}
+		if current.CId != body.CId { // a new cid moves the subcategory, as MoveSubCategory does
+			plan, err := planMove(ctx, h.store, body.ScId, body.CId)
+			if err != nil {
+				return err
+			}
+			if err := plan.carryOut(ctx, h.store); err != nil {
+				return err
+			}
+			version = anyVersion // checked above; the move has written a new one
+		}
+		result, err = h.store.SubCategories.Update(ctx, body, version)
+		return err
+	})
+	if err != nil {
+		moveError(w, r, err, "cid")
+		return
 	}
-
+	writeVersioned(w, http.StatusOK, result)
 }
 
 // Update SubCategory Status
 
-func UpdateSubCategoryStatus(w http.ResponseWriter, r *http.Request) {
-
-	w.Header().Set("Content-Type", "application/json")
-	validate := validator.New()
-
+func (h *Handler) UpdateSubCategoryStatus(w http.ResponseWriter, r *http.Request) {
 	type updateBodystatus struct {
-		ScId     string `json:"scid" validate:"required,alphanum,min=4,max=10"` //value that has to be matched
+		ScId     string `json:"scid" validate:"required,alphanum,min=4,max=26"` //value that has to be matched
 		Scstatus bool   `json:"scstatus"`                                       // value that has to be modified
 	}
 	var bodys updateBodystatus
-	e := json.NewDecoder(r.Body).Decode(&bodys)
-	if e != nil {
-
-		fmt.Print(e)
-		w.WriteHeader(400)
-	}
-	errv := validate.Struct(bodys) // update status struct validation
-	if errv != nil {
-		fmt.Println(errv)
-		w.WriteHeader(401)
-		json.NewEncoder(w).Encode("Validation error")
-	} else {
-		filter1 := bson.D{{"scid", bodys.ScId}} // converting value to BSON type
-		after1 := options.After                 // for returning updated document
-		returnOpt1 := options.FindOneAndUpdateOptions{
-
-			ReturnDocument: &after1,
-		}
-		update1 := bson.D{{"$set", bson.D{{"scstatus", bodys.Scstatus}}}}
-		updateResult1 := subCategoryCollection.FindOneAndUpdate(context.TODO(), filter1, update1, &returnOpt1)
-
-		var result1 primitive.M
-		_ = updateResult1.Decode(&result1)
-
-		json.NewEncoder(w).Encode(result1)
-		w.WriteHeader(200)
+	if !decodeBody(w, r, &bodys) || !validateBody(w, r, bodys) { // update status struct validation
+		return
 	}
+	h.updateStatus(w, r, "subcategory", bodys.ScId, bodys.Scstatus, func(ctx context.Context, version int64) (versioned, error) { // ?cascade=true also updates the subtree
+		return h.store.SubCategories.UpdateStatus(ctx, bodys.ScId, bodys.Scstatus, version)
+	})
 }
 
 //Delete SubCategory
 
-func DeleteSubCategory(w http.ResponseWriter, r *http.Request) {
-
-	w.Header().Set("Content-Type", "application/json")
+func (h *Handler) DeleteSubCategory(w http.ResponseWriter, r *http.Request) {
 	params := mux.Vars(r)["id"] //get Parameter value as string
-	is_alphanumeric := regexp.MustCompile(`^[a-zA-Z0-9]*$`).MatchString(params)
-
-	// _id, err := primitive.ObjectIDFromHex(params) // convert params to mongodb Hex ID
-	// if err != nil {
-	// 	fmt.Printf(err.Error())
-	// }
-	if is_alphanumeric {
-		opts := options.Delete().SetCollation(&options.Collation{}) // to specify language-specific rules for string comparison, such as rules for lettercase
-		res, err := subCategoryCollection.DeleteOne(context.TODO(), bson.D{{"scid", params}}, opts)
-		if err != nil {
-			log.Fatal(err)
-			w.WriteHeader(400)
-		} else {
-			fmt.Printf("deleted %v documents\n", res.DeletedCount)
-			json.NewEncoder(w).Encode(res.DeletedCount) // return number of documents deleted
-			w.WriteHeader(200)
-		}
-	} else {
-		json.NewEncoder(w).Encode("Please enter the correct SubCategory ID!")
-		w.WriteHeader(204)
+	if !validID(w, r, params, "SubCategory") {
+		return
 	}
-
+	h.deleteWithPolicy(w, r, "subcategory", params)
 }
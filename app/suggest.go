@@ -0,0 +1,649 @@
+package app
+
+import (
+	"context"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultSuggestions = 10
+	maxSuggestions     = 50
+)
+
+// suggestion is one typeahead result.
+type suggestion struct {
+	Entity   string `json:"entity"` // product, category, subcategory or brand
+	ID       string `json:"id"`
+	Name     string `json:"name"`
+	Distance int    `json:"distance"` // typos corrected to match; 0 for an exact prefix
+}
+
+// suggestWord is one word of a name, pointing back at its document.
+type suggestWord struct {
+	word string
+	ref  docRef
+}
+
+// suggester answers typeahead queries over the names of products, categories,
+// subcategories and brands. It lives in process and is updated by the stores
+// on every write; reload rebuilds it from the stores, to take in the writes
+// of other instances.
+type suggester struct {
+	mu      sync.RWMutex
+	names   map[docRef]string
+	words   []suggestWord   // sorted by word, for prefix lookups
+	vocab   *trieNode       // distinct words of the names
+	pending []suggestChange // writes made during a reload, nil when none is running
+
+	reloading sync.Mutex // held through a reload
+}
+
+// suggestChange is a name put or deleted while the suggester was reloading.
+type suggestChange struct {
+	ref     docRef
+	name    string
+	deleted bool
+}
+
+func newSuggester() *suggester {
+	return &suggester{names: map[docRef]string{}, vocab: newTrieNode()}
+}
+
+// trieNode is a node of the vocabulary trie: the words below it share the
+// prefix spelled by the path to it.
+type trieNode struct {
+	children map[rune]*trieNode
+	word     string // the word ending here, if any
+	uses     int    // names using the word ending here
+	count    int    // words ending at or below this node
+}
+
+func newTrieNode() *trieNode {
+	return &trieNode{children: map[rune]*trieNode{}}
+}
+
+// add counts one more name using word.
+func (t *trieNode) add(word string) {
+	path := []*trieNode{t}
+	n := t
+	for _, r := range word {
+		child, ok := n.children[r]
+		if !ok {
+			child = newTrieNode()
+			n.children[r] = child
+		}
+		n = child
+		path = append(path, n)
+	}
+	if n.uses++; n.uses == 1 {
+		n.word = word
+		for _, p := range path {
+			p.count++
+		}
+	}
+}
+
+// drop counts one name fewer using word, pruning the branch it leaves empty.
+func (t *trieNode) drop(word string) {
+	path := []*trieNode{t}
+	n := t
+	for _, r := range word {
+		if n = n.children[r]; n == nil {
+			return
+		}
+		path = append(path, n)
+	}
+	if n.uses--; n.uses > 0 {
+		return
+	}
+	n.word = ""
+	runes := []rune(word)
+	for i, p := range path {
+		if p.count--; p.count == 0 && i > 0 {
+			delete(path[i-1].children, runes[i-1])
+			return
+		}
+	}
+}
+
+// near calls found with every word within budget of the prefixDistance of
+// term, and that distance. It walks the trie with one row of the edit
+// distance table per node, leaving branches no row can bring within budget.
+func (t *trieNode) near(term string, budget int, found func(word string, distance int)) {
+	a := []rune(term)
+	row := make([]int, len(a)+1)
+	for i := range row {
+		row[i] = i
+	}
+	for r, child := range t.children {
+		child.walk(a, r, 0, nil, row, len(a), budget, found)
+	}
+}
+
+// walk extends the table by the row for rune r below a node reached by
+// parent; prev2 is the row before, for swaps of neighbours.
+func (t *trieNode) walk(a []rune, r, parent rune, prev2, prev []int, best, budget int, found func(string, int)) {
+	row := make([]int, len(a)+1)
+	row[0] = prev[0] + 1
+	low := row[0]
+	for i := 1; i <= len(a); i++ {
+		cost := 1
+		if a[i-1] == r {
+			cost = 0
+		}
+		row[i] = min3(prev[i]+1, row[i-1]+1, prev[i-1]+cost)
+		if prev2 != nil && i > 1 && a[i-1] == parent && a[i-2] == r && prev2[i-2]+1 < row[i] {
+			row[i] = prev2[i-2] + 1
+		}
+		if row[i] < low {
+			low = row[i]
+		}
+	}
+	if row[len(a)] < best {
+		best = row[len(a)]
+	}
+	if low > budget { // no longer prefix can come closer
+		if best <= budget {
+			t.all(best, found)
+		}
+		return
+	}
+	if t.word != "" && best <= budget {
+		found(t.word, best)
+	}
+	for next, child := range t.children {
+		child.walk(a, next, r, prev, row, best, budget, found)
+	}
+}
+
+// all calls found with every word at or below the node.
+func (t *trieNode) all(distance int, found func(string, int)) {
+	if t.word != "" {
+		found(t.word, distance)
+	}
+	for _, child := range t.children {
+		child.all(distance, found)
+	}
+}
+
+// put records the name of a document, replacing the one it had.
+func (s *suggester) put(ref docRef, name string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.pending != nil {
+		s.pending = append(s.pending, suggestChange{ref: ref, name: name})
+	}
+	s.set(ref, name)
+}
+
+// set records the name of ref. Callers must hold s.mu.
+func (s *suggester) set(ref docRef, name string) {
+	s.remove(ref)
+	s.names[ref] = name
+	for _, w := range distinct(words(name)) {
+		i := sort.Search(len(s.words), func(i int) bool { return s.words[i].word >= w })
+		s.words = append(s.words, suggestWord{})
+		copy(s.words[i+1:], s.words[i:])
+		s.words[i] = suggestWord{w, ref}
+		s.vocab.add(w)
+	}
+}
+
+// delete forgets a document.
+func (s *suggester) delete(ref docRef) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.pending != nil {
+		s.pending = append(s.pending, suggestChange{ref: ref, deleted: true})
+	}
+	s.remove(ref)
+}
+
+// remove drops the name of ref. Callers must hold s.mu.
+func (s *suggester) remove(ref docRef) {
+	name, ok := s.names[ref]
+	if !ok {
+		return
+	}
+	delete(s.names, ref)
+	for _, w := range distinct(words(name)) {
+		i := sort.Search(len(s.words), func(i int) bool { return s.words[i].word >= w })
+		for ; i < len(s.words) && s.words[i].word == w; i++ {
+			if s.words[i].ref == ref {
+				s.words = append(s.words[:i], s.words[i+1:]...)
+				break
+			}
+		}
+		s.vocab.drop(w)
+	}
+}
+
+// suggest returns up to limit names of the given entities whose words start
+// with the query words, allowing a few typos in each. Closer matches come
+// first, then names starting with the query, then shorter names.
+func (s *suggester) suggest(q string, entities map[string]bool, limit int) []suggestion {
+	terms := words(q)
+	if len(terms) == 0 {
+		return []suggestion{}
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	// the longest query word narrows the candidates, the others are checked after
+	longest := terms[0]
+	for _, t := range terms {
+		if len([]rune(t)) > len([]rune(longest)) {
+			longest = t
+		}
+	}
+	var close []string
+	s.vocab.near(longest, typoBudget(longest), func(w string, _ int) { close = append(close, w) })
+	candidates := map[docRef]bool{}
+	for _, w := range close {
+		i := sort.Search(len(s.words), func(i int) bool { return s.words[i].word >= w })
+		for ; i < len(s.words) && s.words[i].word == w; i++ {
+			if len(entities) == 0 || entities[s.words[i].ref.Entity] {
+				candidates[s.words[i].ref] = true
+			}
+		}
+	}
+
+	results := []suggestion{}
+	for ref := range candidates {
+		name := s.names[ref]
+		nameWords := words(name)
+		total := 0
+		for _, t := range terms {
+			best := -1
+			for _, w := range nameWords {
+				if d := prefixDistance(t, w); d <= typoBudget(t) && (best < 0 || d < best) {
+					best = d
+				}
+			}
+			if best < 0 {
+				total = -1
+				break
+			}
+			total += best
+		}
+		if total >= 0 {
+			results = append(results, suggestion{ref.Entity, ref.ID, name, total})
+		}
+	}
+	lq := strings.ToLower(strings.TrimSpace(q))
+	sort.Slice(results, func(i, j int) bool {
+		a, b := results[i], results[j]
+		if a.Distance != b.Distance {
+			return a.Distance < b.Distance
+		}
+		ap, bp := strings.HasPrefix(strings.ToLower(a.Name), lq), strings.HasPrefix(strings.ToLower(b.Name), lq)
+		if ap != bp {
+			return ap
+		}
+		if len(a.Name) != len(b.Name) {
+			return len(a.Name) < len(b.Name)
+		}
+		if a.Name != b.Name {
+			return a.Name < b.Name
+		}
+		return a.Entity+a.ID < b.Entity+b.ID
+	})
+	if len(results) > limit {
+		results = results[:limit]
+	}
+	return results
+}
+
+func distinct(ws []string) []string {
+	seen := map[string]bool{}
+	out := ws[:0]
+	for _, w := range ws {
+		if !seen[w] {
+			seen[w] = true
+			out = append(out, w)
+		}
+	}
+	return out
+}
+
+// typoBudget is how many typos a query word of this length may contain.
+func typoBudget(term string) int {
+	switch n := len([]rune(term)); {
+	case n < 3:
+		return 0
+	case n < 6:
+		return 1
+	}
+	return 2
+}
+
+// prefixDistance is the fewest edits (insertions, deletions, substitutions
+// and swaps of neighbours) turning term into a prefix of word.
+func prefixDistance(term, word string) int {
+	a, b := []rune(term), []rune(word)
+	// rows over term, columns over word; the answer is the best cell of the last row
+	prev2 := make([]int, len(b)+1)
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min3(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+			if i > 1 && j > 1 && a[i-1] == b[j-2] && a[i-2] == b[j-1] && prev2[j-2]+1 < cur[j] {
+				cur[j] = prev2[j-2] + 1
+			}
+		}
+		prev2, prev, cur = prev, cur, prev2
+	}
+	best := prev[0]
+	for _, d := range prev {
+		if d < best {
+			best = d
+		}
+	}
+	return best
+}
+
+func min3(a, b, c int) int {
+	if b < a {
+		a = b
+	}
+	if c < a {
+		a = c
+	}
+	return a
+}
+
+// reload rebuilds the suggester from the stores, e.g. at startup against an
+// existing database, and swaps it in whole. The writes made through this
+// instance while it reads the stores may be missing from what it read, so
+// they are recorded and replayed onto the rebuilt suggester before the swap.
+func (sg *suggester) reload(ctx context.Context, s Stores) error {
+	sg.reloading.Lock()
+	defer sg.reloading.Unlock()
+	sg.mu.Lock()
+	sg.pending = []suggestChange{}
+	sg.mu.Unlock()
+	defer func() {
+		sg.mu.Lock()
+		sg.pending = nil
+		sg.mu.Unlock()
+	}()
+
+	fresh := newSuggester()
+	products, err := s.Products.GetAll(ctx)
+	if err != nil {
+		return err
+	}
+	for _, p := range products {
+		fresh.put(docRef{"product", p.PId}, p.Pname)
+	}
+	categories, err := s.Categories.GetAll(ctx)
+	if err != nil {
+		return err
+	}
+	for _, c := range categories { // subcategories included
+		fresh.put(nodeRef(c), c.Cname)
+	}
+	brands, err := s.Brands.GetAll(ctx)
+	if err != nil {
+		return err
+	}
+	for _, b := range brands {
+		fresh.put(docRef{"brand", b.BId}, b.Bname)
+	}
+	sg.mu.Lock()
+	defer sg.mu.Unlock()
+	for _, c := range sg.pending {
+		if c.deleted {
+			fresh.remove(c.ref)
+		} else {
+			fresh.set(c.ref, c.name)
+		}
+	}
+	sg.names, sg.words, sg.vocab = fresh.names, fresh.words, fresh.vocab
+	return nil
+}
+
+// refreshSuggestions reloads the suggester every interval until ctx is done,
+// so that an instance picks up the writes made through other instances.
+func refreshSuggestions(ctx context.Context, s Stores, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := s.suggestions.reload(ctx, s); err != nil && ctx.Err() == nil {
+				logWarnf("refreshing suggestions: %v", err)
+			}
+		}
+	}
+}
+
+//Suggest names as the user types
+
+func (h *Handler) Suggest(w http.ResponseWriter, r *http.Request) {
+	params := r.URL.Query()
+	var problems []fieldError
+	q := params.Get("q")
+	if len(words(q)) == 0 {
+		problems = append(problems, fieldError{Field: "q", Message: "q must contain at least one word"})
+	}
+	limit := defaultSuggestions
+	if v := params.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxSuggestions {
+			problems = append(problems, fieldError{Field: "limit", Message: "limit must be a number from 1 to " + strconv.Itoa(maxSuggestions)})
+		}
+		limit = n
+	}
+	entities := map[string]bool{}
+	if v := params.Get("types"); v != "" {
+		for _, t := range strings.Split(v, ",") {
+			switch t {
+			case "product", "category", "subcategory", "brand":
+				entities[t] = true
+			default:
+				problems = append(problems, fieldError{Field: "types", Message: "types must list product, category, subcategory or brand"})
+			}
+		}
+	}
+	if len(problems) > 0 {
+		writeError(w, r, http.StatusBadRequest, codeBadRequest, "Invalid query parameters", problems...)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string][]suggestion{"data": h.store.suggestions.suggest(q, entities, limit)})
+}
+
+// withSuggestions wraps the product, category, subcategory and brand stores
+// so that every write through them also updates the suggester, once the
+// unit of work it is part of commits.
+func withSuggestions(s Stores, sg *suggester) Stores {
+	s.suggestions = sg
+	s.Products = suggestedProductStore{s.Products, sg}
+	s.Categories = suggestedCategoryStore{s.Categories, sg}
+	s.SubCategories = suggestedSubCategoryStore{s.SubCategories, sg}
+	s.Brands = suggestedBrandStore{s.Brands, sg}
+	return s
+}
+
+type suggestedProductStore struct {
+	ProductStore
+	sg *suggester
+}
+
+func (s suggestedProductStore) Insert(ctx context.Context, prod product) (interface{}, error) {
+	id, err := s.ProductStore.Insert(ctx, prod)
+	if err == nil {
+		afterCommit(ctx, func() { s.sg.put(docRef{"product", prod.PId}, prod.Pname) })
+	}
+	return id, err
+}
+
+func (s suggestedProductStore) Update(ctx context.Context, body productUpdate, version int64) (product, error) {
+	p, err := s.ProductStore.Update(ctx, body, version)
+	if err == nil {
+		afterCommit(ctx, func() { s.sg.put(docRef{"product", p.PId}, p.Pname) })
+	}
+	return p, err
+}
+
+func (s suggestedProductStore) Restore(ctx context.Context, prod product, version int64) (product, error) {
+	p, err := s.ProductStore.Restore(ctx, prod, version)
+	if err == nil {
+		afterCommit(ctx, func() { s.sg.put(docRef{"product", p.PId}, p.Pname) })
+	}
+	return p, err
+}
+
+func (s suggestedProductStore) Delete(ctx context.Context, pid string, version int64) (int64, error) {
+	n, err := s.ProductStore.Delete(ctx, pid, version)
+	if err == nil {
+		afterCommit(ctx, func() { s.sg.delete(docRef{"product", pid}) })
+	}
+	return n, err
+}
+
+type suggestedCategoryStore struct {
+	CategoryStore
+	sg *suggester
+}
+
+// nodeRef names a category node the way the subcategory view would: a
+// category at the top, a subcategory below it.
+func nodeRef(c category) docRef {
+	if c.CParent == "" {
+		return docRef{"category", c.CId}
+	}
+	return docRef{"subcategory", c.CId}
+}
+
+// relabel files a node under the entity it now is and drops the other.
+func (s suggestedCategoryStore) relabel(c category) {
+	s.sg.delete(docRef{"category", c.CId})
+	s.sg.delete(docRef{"subcategory", c.CId})
+	s.sg.put(nodeRef(c), c.Cname)
+}
+
+func (s suggestedCategoryStore) Insert(ctx context.Context, cat category) (interface{}, error) {
+	id, err := s.CategoryStore.Insert(ctx, cat)
+	if err == nil {
+		afterCommit(ctx, func() { s.sg.put(nodeRef(cat), cat.Cname) })
+	}
+	return id, err
+}
+
+func (s suggestedCategoryStore) Update(ctx context.Context, body categoryUpdate, version int64) (category, error) {
+	c, err := s.CategoryStore.Update(ctx, body, version)
+	if err == nil {
+		afterCommit(ctx, func() { s.sg.put(nodeRef(c), c.Cname) })
+	}
+	return c, err
+}
+
+func (s suggestedCategoryStore) Restore(ctx context.Context, cat category, version int64) (category, error) {
+	c, err := s.CategoryStore.Restore(ctx, cat, version)
+	if err == nil {
+		afterCommit(ctx, func() { s.sg.put(nodeRef(c), c.Cname) })
+	}
+	return c, err
+}
+
+func (s suggestedCategoryStore) Move(ctx context.Context, cid, parent string, path []string) (category, error) {
+	c, err := s.CategoryStore.Move(ctx, cid, parent, path)
+	if err == nil {
+		afterCommit(ctx, func() { s.relabel(c) })
+	}
+	return c, err
+}
+
+func (s suggestedCategoryStore) Delete(ctx context.Context, cid string, version int64) (int64, error) {
+	n, err := s.CategoryStore.Delete(ctx, cid, version)
+	if err == nil {
+		afterCommit(ctx, func() {
+			s.sg.delete(docRef{"category", cid})
+			s.sg.delete(docRef{"subcategory", cid})
+		})
+	}
+	return n, err
+}
+
+type suggestedSubCategoryStore struct {
+	SubCategoryStore
+	sg *suggester
+}
+
+func (s suggestedSubCategoryStore) Insert(ctx context.Context, subc subcategory) (interface{}, error) {
+	id, err := s.SubCategoryStore.Insert(ctx, subc)
+	if err == nil {
+		afterCommit(ctx, func() { s.sg.put(docRef{"subcategory", subc.ScId}, subc.Scname) })
+	}
+	return id, err
+}
+
+func (s suggestedSubCategoryStore) Update(ctx context.Context, body subcategoryUpdate, version int64) (subcategory, error) {
+	sc, err := s.SubCategoryStore.Update(ctx, body, version)
+	if err == nil {
+		afterCommit(ctx, func() { s.sg.put(docRef{"subcategory", sc.ScId}, sc.Scname) })
+	}
+	return sc, err
+}
+
+func (s suggestedSubCategoryStore) Delete(ctx context.Context, scid string, version int64) (int64, error) {
+	n, err := s.SubCategoryStore.Delete(ctx, scid, version)
+	if err == nil {
+		afterCommit(ctx, func() { s.sg.delete(docRef{"subcategory", scid}) })
+	}
+	return n, err
+}
+
+type suggestedBrandStore struct {
+	BrandStore
+	sg *suggester
+}
+
+func (s suggestedBrandStore) Insert(ctx context.Context, b brand) (interface{}, error) {
+	id, err := s.BrandStore.Insert(ctx, b)
+	if err == nil {
+		afterCommit(ctx, func() { s.sg.put(docRef{"brand", b.BId}, b.Bname) })
+	}
+	return id, err
+}
+
+func (s suggestedBrandStore) Update(ctx context.Context, body brandUpdate, version int64) (brand, error) {
+	b, err := s.BrandStore.Update(ctx, body, version)
+	if err == nil {
+		afterCommit(ctx, func() { s.sg.put(docRef{"brand", b.BId}, b.Bname) })
+	}
+	return b, err
+}
+
+func (s suggestedBrandStore) Restore(ctx context.Context, br brand, version int64) (brand, error) {
+	b, err := s.BrandStore.Restore(ctx, br, version)
+	if err == nil {
+		afterCommit(ctx, func() { s.sg.put(docRef{"brand", b.BId}, b.Bname) })
+	}
+	return b, err
+}
+
+func (s suggestedBrandStore) Delete(ctx context.Context, bid string, version int64) (int64, error) {
+	n, err := s.BrandStore.Delete(ctx, bid, version)
+	if err == nil {
+		afterCommit(ctx, func() { s.sg.delete(docRef{"brand", bid}) })
+	}
+	return n, err
+}
@@ -0,0 +1,40 @@
+package app
+
+import (
+	"context"
+	"testing"
+)
+
+// racingProducts runs during after reading every product, as a write landing
+// while the suggester reloads would.
+type racingProducts struct {
+	ProductStore
+	during func()
+}
+
+func (s racingProducts) GetAll(ctx context.Context) ([]product, error) {
+	all, err := s.ProductStore.GetAll(ctx)
+	s.during()
+	return all, err
+}
+
+func TestReloadKeepsConcurrentWrites(t *testing.T) {
+	s := newCatalog(t)
+	sg := s.suggestions
+	s.Products = racingProducts{s.Products, func() {
+		sg.put(docRef{"brand", "BRD9"}, "Zenith")
+		sg.delete(docRef{"product", "PRD3"})
+	}}
+	if err := sg.reload(context.Background(), s); err != nil {
+		t.Fatal(err)
+	}
+	if got := sg.suggest("zenith", nil, 10); len(got) != 1 || got[0].ID != "BRD9" {
+		t.Errorf("zenith: %+v, want the brand put during the reload", got)
+	}
+	if got := sg.suggest("phone", map[string]bool{"product": true}, 10); len(got) != 1 || got[0].ID != "PRD1" {
+		t.Errorf("phone: %+v, want PRD1 alone, PRD3 deleted during the reload", got)
+	}
+	if sg.pending != nil {
+		t.Errorf("%d changes still recorded after the reload", len(sg.pending))
+	}
+}
@@ -0,0 +1,163 @@
+package app
+
+import (
+	"context"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+type commitHooksKey struct{}
+
+// commitHooks are the in-process updates, such as the suggester's, waiting
+// for the unit of work that caused them to commit.
+type commitHooks struct{ fns []func() }
+
+// afterCommit runs fn once the unit of work in ctx commits, and drops it if
+// the unit of work fails. Outside a unit of work it runs fn at once.
+func afterCommit(ctx context.Context, fn func()) {
+	if hooks, ok := ctx.Value(commitHooksKey{}).(*commitHooks); ok {
+		hooks.fns = append(hooks.fns, fn)
+		return
+	}
+	fn()
+}
+
+func (h *commitHooks) run() {
+	for _, fn := range h.fns {
+		fn()
+	}
+}
+
+// memTx is the unit of work of the in-memory store. It holds the database
+// lock for its whole run, so nothing it writes is seen before it ends, and
+// keeps a copy of every collection it changes to put back if it fails.
+type memTx struct{ db *memoryDB }
+
+type memTxKey struct{}
+
+// memJournal is the state of an open memory unit of work.
+type memJournal struct {
+	db    *memoryDB
+	saved map[string]*memTable // collection -> contents before the first write, nil if it did not exist
+}
+
+func (t memTx) Run(ctx context.Context, fn func(ctx context.Context) error) error {
+	if j, ok := ctx.Value(memTxKey{}).(*memJournal); ok && j.db == t.db {
+		return fn(ctx) // already inside one: join it
+	}
+	t.db.mu.Lock()
+	j := &memJournal{db: t.db, saved: map[string]*memTable{}}
+	hooks := &commitHooks{}
+	committed := false
+	defer func() {
+		if !committed {
+			j.rollback()
+		}
+		t.db.mu.Unlock()
+		if committed {
+			hooks.run()
+		}
+	}()
+	ctx = context.WithValue(context.WithValue(ctx, memTxKey{}, j), commitHooksKey{}, hooks)
+	if err := fn(ctx); err != nil {
+		return err
+	}
+	committed = true
+	return nil
+}
+
+// save copies the named collection before its first write in the unit of work.
+func (j *memJournal) save(name string) {
+	if _, ok := j.saved[name]; ok {
+		return
+	}
+	t, ok := j.db.colls[name]
+	if !ok {
+		j.saved[name] = nil
+		return
+	}
+	cp := &memTable{docs: make(map[string]bson.M, len(t.docs)), order: append([]string{}, t.order...)}
+	for id, doc := range t.docs {
+		d := make(bson.M, len(doc))
+		for k, v := range doc {
+			d[k] = v
+		}
+		cp.docs[id] = d
+	}
+	j.saved[name] = cp
+}
+
+func (j *memJournal) rollback() {
+	for name, t := range j.saved {
+		if t == nil {
+			delete(j.db.colls, name)
+		} else {
+			j.db.colls[name] = t
+		}
+	}
+}
+
+// read locks the database for reading, unless ctx is in a unit of work on
+// it, which holds the lock already. It returns the unlock function.
+func (db *memoryDB) read(ctx context.Context) func() {
+	if j, ok := ctx.Value(memTxKey{}).(*memJournal); ok && j.db == db {
+		return func() {}
+	}
+	db.mu.RLock()
+	return db.mu.RUnlock
+}
+
+// write locks the database for writing to the named collection; in a unit
+// of work it saves the collection for a rollback instead.
+func (db *memoryDB) write(ctx context.Context, name string) func() {
+	if j, ok := ctx.Value(memTxKey{}).(*memJournal); ok && j.db == db {
+		j.save(name)
+		return func() {}
+	}
+	db.mu.Lock()
+	return db.mu.Unlock
+}
+
+// mongoTx is the unit of work of the Mongo store: a transaction, on
+// deployments that support them. On a standalone server, which the app only
+// runs on with allowNonTransactional, the writes are made one by one and a
+// failure leaves the ones already made.
+type mongoTx struct {
+	client  *mongo.Client
+	enabled bool
+}
+
+func (t mongoTx) Run(ctx context.Context, fn func(ctx context.Context) error) error {
+	if !t.enabled || mongo.SessionFromContext(ctx) != nil {
+		return fn(ctx) // no transactions, or already inside one
+	}
+	sess, err := t.client.StartSession()
+	if err != nil {
+		return err
+	}
+	defer sess.EndSession(ctx)
+	var hooks *commitHooks
+	_, err = sess.WithTransaction(ctx, func(sc mongo.SessionContext) (interface{}, error) {
+		hooks = &commitHooks{} // a retried transaction starts over
+		return nil, fn(context.WithValue(sc, commitHooksKey{}, hooks))
+	})
+	if err != nil {
+		return err
+	}
+	hooks.run()
+	return nil
+}
+
+// supportsTransactions reports whether the deployment is a replica set or a
+// sharded cluster, the ones where Mongo offers multi-document transactions.
+func supportsTransactions(ctx context.Context, db *mongo.Database) (bool, error) {
+	var hello struct {
+		SetName string `bson:"setName"`
+		Msg     string `bson:"msg"`
+	}
+	if err := db.RunCommand(ctx, bson.D{{Key: "isMaster", Value: 1}}).Decode(&hello); err != nil {
+		return false, err
+	}
+	return hello.SetName != "" || hello.Msg == "isdbgrid", nil
+}
@@ -0,0 +1,189 @@
+package app
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/go-playground/locales/en"
+	"github.com/go-playground/locales/es"
+	"github.com/go-playground/locales/fr"
+	ut "github.com/go-playground/universal-translator"
+	"github.com/go-playground/validator/v10"
+	en_translations "github.com/go-playground/validator/v10/translations/en"
+	es_translations "github.com/go-playground/validator/v10/translations/es"
+	fr_translations "github.com/go-playground/validator/v10/translations/fr"
+)
+
+// decodeBody reads the JSON request body into dst. On failure it answers 400 and returns false.
+func decodeBody(w http.ResponseWriter, r *http.Request, dst interface{}) bool {
+	if err := json.NewDecoder(r.Body).Decode(dst); err != nil {
+		logDebugf("%v", err)
+		writeError(w, r, http.StatusBadRequest, codeBadRequest, "Request body is not valid JSON: "+err.Error())
+		return false
+	}
+	return true
+}
+
+// validate holds the rules of every request struct; translator renders its
+// errors in the language asked for by Accept-Language (English by default).
+var validate, translator = newValidator()
+
+// rangeMessages are the per-locale messages for a string that must be between
+// a minimum and a maximum length; the validator only knows min and max separately.
+var rangeMessages = map[string]string{
+	"en": "{0} must be between {1} and {2} characters",
+	"es": "{0} debe tener entre {1} y {2} caracteres",
+	"fr": "{0} doit contenir entre {1} et {2} caractères",
+}
+
+func newValidator() (*validator.Validate, *ut.UniversalTranslator) {
+	v := validator.New()
+	// report fields by their JSON name, e.g. "subprod.categoryid"
+	v.RegisterTagNameFunc(func(f reflect.StructField) string {
+		name := strings.SplitN(f.Tag.Get("json"), ",", 2)[0]
+		if name == "-" {
+			return ""
+		}
+		return name
+	})
+
+	english := en.New()
+	uni := ut.New(english, english, es.New(), fr.New())
+	register := map[string]func(*validator.Validate, ut.Translator) error{
+		"en": en_translations.RegisterDefaultTranslations,
+		"es": es_translations.RegisterDefaultTranslations,
+		"fr": fr_translations.RegisterDefaultTranslations,
+	}
+	for locale, fn := range register {
+		trans, _ := uni.GetTranslator(locale)
+		if err := fn(v, trans); err != nil {
+			panic(err) // the bundled translations are static, so this is a programming error
+		}
+		if err := trans.Add("between", rangeMessages[locale], false); err != nil {
+			panic(err)
+		}
+	}
+	return v, uni
+}
+
+// translatorFor picks the translator for the request's Accept-Language
+// header, honouring q-values and falling back to English.
+func translatorFor(r *http.Request) ut.Translator {
+	type lang struct {
+		tag string
+		q   float64
+	}
+	var langs []lang
+	for _, part := range strings.Split(r.Header.Get("Accept-Language"), ",") {
+		fields := strings.Split(strings.TrimSpace(part), ";")
+		if fields[0] == "" || fields[0] == "*" {
+			continue
+		}
+		q := 1.0
+		for _, f := range fields[1:] {
+			if v := strings.TrimPrefix(strings.TrimSpace(f), "q="); v != f {
+				if parsed, err := strconv.ParseFloat(v, 64); err == nil {
+					q = parsed
+				}
+			}
+		}
+		langs = append(langs, lang{strings.ReplaceAll(fields[0], "-", "_"), q})
+	}
+	sort.SliceStable(langs, func(i, j int) bool { return langs[i].q > langs[j].q })
+
+	locales := make([]string, 0, 2*len(langs))
+	for _, l := range langs {
+		locales = append(locales, l.tag, strings.SplitN(l.tag, "_", 2)[0]) // "fr_CA" falls back to "fr"
+	}
+	trans, _ := translator.FindTranslator(locales...)
+	return trans
+}
+
+// validateBody runs the struct validation rules on v. On failure it answers
+// 422 with one translated message per invalid field and returns false.
+func validateBody(w http.ResponseWriter, r *http.Request, v interface{}) bool {
+	err := validate.Struct(v)
+	if err == nil {
+		return true
+	}
+	logDebugf("%v", err)
+	var verrs validator.ValidationErrors
+	if !errors.As(err, &verrs) {
+		writeError(w, r, http.StatusBadRequest, codeBadRequest, err.Error())
+		return false
+	}
+	trans := translatorFor(r)
+	details := make([]fieldError, 0, len(verrs))
+	for _, fe := range verrs {
+		details = append(details, fieldError{Field: fieldPath(fe), Message: fieldMessage(trans, reflect.TypeOf(v), fe)})
+	}
+	writeError(w, r, http.StatusUnprocessableEntity, codeValidation, "Validation error", details...)
+	return false
+}
+
+// fieldMessage translates one validation error. A string failing min or max
+// is reported with its full length range when the field declares both.
+func fieldMessage(trans ut.Translator, t reflect.Type, fe validator.FieldError) string {
+	if (fe.Tag() == "min" || fe.Tag() == "max") && fe.Kind() == reflect.String {
+		if min, max, ok := lengthRange(t, fe.StructNamespace()); ok {
+			if msg, err := trans.T("between", fe.Field(), min, max); err == nil {
+				return msg
+			}
+		}
+	}
+	return fe.Translate(trans)
+}
+
+// lengthRange looks up the min and max parameters declared on the struct
+// field at the given namespace, e.g. "product.SubProd.BrandId".
+func lengthRange(t reflect.Type, namespace string) (min, max string, ok bool) {
+	path := strings.Split(namespace, ".")[1:]
+	var field reflect.StructField
+	for _, name := range path {
+		for t.Kind() == reflect.Ptr {
+			t = t.Elem()
+		}
+		if t.Kind() != reflect.Struct {
+			return "", "", false
+		}
+		if field, ok = t.FieldByName(name); !ok {
+			return "", "", false
+		}
+		t = field.Type
+	}
+	for _, rule := range strings.Split(field.Tag.Get("validate"), ",") {
+		if v := strings.TrimPrefix(rule, "min="); v != rule {
+			min = v
+		}
+		if v := strings.TrimPrefix(rule, "max="); v != rule {
+			max = v
+		}
+	}
+	return min, max, min != "" && max != ""
+}
+
+// fieldPath returns the JSON path of an invalid field without the struct name.
+func fieldPath(fe validator.FieldError) string {
+	ns := fe.Namespace()
+	if i := strings.Index(ns, "."); i >= 0 {
+		return ns[i+1:]
+	}
+	return ns
+}
+
+var alphanumeric = regexp.MustCompile(`^[a-zA-Z0-9]+$`)
+
+// validID checks an ID taken from the URL. On failure it answers 400 and returns false.
+func validID(w http.ResponseWriter, r *http.Request, id, entity string) bool {
+	if !alphanumeric.MatchString(id) {
+		writeError(w, r, http.StatusBadRequest, codeInvalidID, "Please enter the correct "+entity+" ID!")
+		return false
+	}
+	return true
+}
@@ -1,236 +1,128 @@
 package app
 
 import (
-	"context"
-	"encoding/json"
-	"fmt"
-	"log"
 	"net/http"
-	"regexp"
+	"time"
 
-	"github.com/go-playground/validator/v10"
 	"github.com/gorilla/mux"
-	"go.mongodb.org/mongo-driver/bson"
-	"go.mongodb.org/mongo-driver/bson/primitive"
-	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
 /// struct for storing data
 type varient struct {
-	VId         string `json:"vid" validate:"required,alphanum,min=4,max=10"`
-	Vname       string `json:"vname" validate:"required,min=3,max=20"`
-	Vdesc       string `json:"vdesc" validate:"required,min=5,max=100"`
-	Vcreatedby  string `json:"vcreatedby" validate:"required,min=3,max=20"`
-	Vmodifiedby string `json:"vmodifiedby" validate:"required,min=3,max=20"`
-	Vstatus     bool   `json:"vstatus"`
+	VId         string    `json:"vid" validate:"omitempty,alphanum,min=4,max=26"`
+	Vname       string    `json:"vname" validate:"required,min=3,max=20"`
+	Vdesc       string    `json:"vdesc" validate:"required,min=5,max=100"`
+	Vcreatedby  string    `json:"vcreatedby" validate:"required,min=3,max=20"`
+	Vmodifiedby string    `json:"vmodifiedby" validate:"required,min=3,max=20"`
+	Vstatus     bool      `json:"vstatus"`
+	Version     int64     `json:"version"`                    // one more on every write; sent as the ETag
+	CreatedAt   time.Time `json:"createdAt" bson:"createdAt"` // set by the server
+	UpdatedAt   time.Time `json:"updatedAt" bson:"updatedAt"`
+	CreatedBy   string    `json:"createdBy,omitempty" bson:"createdBy,omitempty"` // the user named by the actor header, if any
+	UpdatedBy   string    `json:"updatedBy,omitempty" bson:"updatedBy,omitempty"`
 }
 
-var varientCollection = db().Database("ProductApp").Collection("Varient") // get collection "users" from db() which returns *mongo.Client
+// struct for updating data
+type varientUpdate struct {
+	VId         string `json:"vid" validate:"required,alphanum,min=4,max=26"` //value that has to be matched
+	Vname       string `json:"vname" validate:"required,min=3,max=20"`        // value that has to be modified
+	Vdesc       string `json:"vdesc" validate:"required,min=5,max=100"`       // value that has to be modified
+	Vmodifiedby string `json:"vmodifiedby" validate:"required,min=3,max=20"`  // value that has to be modified
+}
 
 // Create Varient
 
-func CreateVarient(w http.ResponseWriter, r *http.Request) {
-	validate := validator.New()
-	w.Header().Set("Content-Type", "application/json") // for adding Content-type
+func (h *Handler) CreateVarient(w http.ResponseWriter, r *http.Request) {
 	var varient varient
-
-	err := json.NewDecoder(r.Body).Decode(&varient) // storing in person variable of type user
-	if err != nil {
-		fmt.Print(err)
-		w.WriteHeader(400)
+	if !decodeBody(w, r, &varient) || !validateBody(w, r, varient) { //create struct validation
+		return
 	}
-	errv := validate.Struct(varient) //create struct validation
-	if errv != nil {
-		fmt.Println(errv)
-		w.WriteHeader(401)
-		json.NewEncoder(w).Encode("Validation error")
-	} else {
-		vcount, errv := varientCollection.CountDocuments(context.TODO(), bson.D{{"vid", varient.VId}})
-		fmt.Println("check count of cid:", vcount)
-		if errv != nil {
-			log.Fatal(errv)
-		} else {
-			if vcount == 0 {
-				insertResult, err := varientCollection.InsertOne(context.TODO(), varient)
-				if err != nil {
-					log.Fatal(err)
-					w.WriteHeader(500)
-				} else {
-					fmt.Println("Inserted a single document: ", insertResult)
-					json.NewEncoder(w).Encode(insertResult.InsertedID) // return the mongodb ID of generated document
-					w.WriteHeader(200)
-				}
-			} else {
-				json.NewEncoder(w).Encode("Dupilicate Varient!")
-			}
-		}
+	if !h.newID(w, r, varientIDs, &varient.VId, h.store.Varients.Exists) {
+		return
+	}
+	if _, err := h.store.Varients.Insert(r.Context(), varient); err != nil {
+		storeError(w, r, err)
+		return
+	}
+	created, err := h.store.Varients.Get(r.Context(), varient.VId) // as stored, with its version and stamps
+	if err != nil {
+		storeError(w, r, err)
+		return
 	}
+	logInfof("inserted a single document: %v", created.VId)
+	w.Header().Set("Location", "/api/GetVarient/"+created.VId)
+	writeVersioned(w, http.StatusCreated, created) // return the created document with its ETag
 }
 
 // Get Varient
 
-func GetVarient(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Content-Type", "application/json")
+func (h *Handler) GetVarient(w http.ResponseWriter, r *http.Request) {
 	params := mux.Vars(r)["id"] //get Parameter value as string
-	is_alphanumeric := regexp.MustCompile(`^[a-zA-Z0-9]*$`).MatchString(params)
-	// var body varient
-	// e := json.NewDecoder(r.Body).Decode(&body)
-	// if e != nil {
-
-	// 	fmt.Print(e)
-	// }
-	if is_alphanumeric {
-		var result primitive.M //  an unordered representation of a BSON document which is a Map
-		filter := bson.M{"vid": params}
-		err := varientCollection.FindOne(context.TODO(), filter).Decode(&result)
-		if err != nil {
-
-			fmt.Println(err)
-			json.NewEncoder(w).Encode("No data found!")
-
-		} else {
-			json.NewEncoder(w).Encode(result) // returns a Map containing document
-			w.WriteHeader(200)
-		}
-	} else {
-		json.NewEncoder(w).Encode("Please enter the correct Varient ID should be alphanumeric!")
-		w.WriteHeader(204)
+	if !validID(w, r, params, "Varient") {
+		return
 	}
-}
-
-// Get All Varient
-
-func GetAllVarient(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Content-Type", "application/json")
-	var results []primitive.M                                      //slice for multiple documents
-	cur, err := varientCollection.Find(context.TODO(), bson.D{{}}) //returns a *mongo.Cursor
+	result, err := h.store.Varients.Get(r.Context(), params)
 	if err != nil {
-
-		fmt.Println(err)
-		w.WriteHeader(400)
-
+		storeError(w, r, err)
+		return
 	}
-	for cur.Next(context.TODO()) { //Next() gets the next document for corresponding cursor
+	writeVersioned(w, http.StatusOK, result) // returns the document with its ETag
+}
 
-		var elem primitive.M
-		err := cur.Decode(&elem)
-		if err != nil {
-			log.Fatal(err)
-			w.WriteHeader(500)
-		}
+// Get All Varient
 
-		results = append(results, elem) // appending document pointed by Next()
-	}
-	cur.Close(context.TODO()) // close the cursor once stream of documents has exhausted
-	json.NewEncoder(w).Encode(results)
-	w.WriteHeader(200)
+func (h *Handler) GetAllVarient(w http.ResponseWriter, r *http.Request) {
+	serveList[varient](w, r, h.store.Varients, "vid", varientFilters, nil)
 }
 
 //Update Varient of Varient Id
 
-func UpdateVarient(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Content-Type", "application/json")
-	validate := validator.New()
-
-	type updateBody struct {
-		VId         string `json:"vid" validate:"required,alphanum,min=4,max=10"` //value that has to be matched
-		Vname       string `json:"vname" validate:"required,min=3,max=20"`        // value that has to be modified
-		Vdesc       string `json:"vdesc" validate:"required,min=5,max=100"`       // value that has to be modified
-		Vmodifiedby string `json:"vmodifiedby" validate:"required,min=3,max=20"`  // value that has to be modified
+func (h *Handler) UpdateVarient(w http.ResponseWriter, r *http.Request) {
+	var body varientUpdate
+	if !decodeBody(w, r, &body) || !validateBody(w, r, body) { // update struct validation
+		return
 	}
-	var body updateBody
-	e := json.NewDecoder(r.Body).Decode(&body)
-	if e != nil {
-
-		fmt.Print(e)
-		w.WriteHeader(400)
-
+	version, ok := ifMatch(w, r)
+	if !ok {
+		return
 	}
-	errv := validate.Struct(body) // update struct validation
-	if errv != nil {
-		fmt.Println(errv)
-		w.WriteHeader(401)
-		json.NewEncoder(w).Encode("Validation error")
-	} else {
-		filter := bson.D{{"vid", body.VId}} // converting value to BSON type
-		after := options.After              // for returning updated document
-		returnOpt := options.FindOneAndUpdateOptions{
-
-			ReturnDocument: &after,
-		}
-		update := bson.D{{"$set", bson.D{{"vname", body.Vname}, {"vdesc", body.Vdesc}, {"vmodifiedby", body.Vmodifiedby}}}}
-		updateResult := varientCollection.FindOneAndUpdate(context.TODO(), filter, update, &returnOpt)
-
-		var result primitive.M
-		_ = updateResult.Decode(&result)
-
-		json.NewEncoder(w).Encode(result)
-		w.WriteHeader(200)
+	result, err := h.store.Varients.Update(r.Context(), body, version)
+	if err != nil {
+		storeError(w, r, err)
+		return
 	}
+	writeVersioned(w, http.StatusOK, result)
 }
 
 // Update Varient Status
 
-func UpdateVarientStatus(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Content-Type", "application/json")
-	validate := validator.New()
+func (h *Handler) UpdateVarientStatus(w http.ResponseWriter, r *http.Request) {
 	type updateBody struct {
-		VId     string `json:"vid" validate:"required,alphanum,min=4,max=10"` //value that has to be matched
+		VId     string `json:"vid" validate:"required,alphanum,min=4,max=26"` //value that has to be matched
 		Vstatus bool   `json:"vstatus"`                                       // value that has to be modified
 	}
 	var body updateBody
-	e := json.NewDecoder(r.Body).Decode(&body)
-	if e != nil {
-
-		fmt.Print(e)
-		w.WriteHeader(400)
+	if !decodeBody(w, r, &body) || !validateBody(w, r, body) { // update status struct validation
+		return
+	}
+	version, ok := ifMatch(w, r)
+	if !ok {
+		return
 	}
-	errv := validate.Struct(body) // update status struct validation
-	if errv != nil {
-		fmt.Println(errv)
-		w.WriteHeader(401)
-		json.NewEncoder(w).Encode("Validation error")
-	} else {
-		filter := bson.D{{"vid", body.VId}} // converting value to BSON type
-		after := options.After              // for returning updated document
-		returnOpt := options.FindOneAndUpdateOptions{
-
-			ReturnDocument: &after,
-		}
-		update := bson.D{{"$set", bson.D{{"vstatus", body.Vstatus}}}}
-		updateResult := varientCollection.FindOneAndUpdate(context.TODO(), filter, update, &returnOpt)
-
-		var result primitive.M
-		_ = updateResult.Decode(&result)
-
-		json.NewEncoder(w).Encode(result)
-		w.WriteHeader(200)
+	result, err := h.store.Varients.UpdateStatus(r.Context(), body.VId, body.Vstatus, version)
+	if err != nil {
+		storeError(w, r, err)
+		return
 	}
+	writeVersioned(w, http.StatusOK, result)
 }
 
 //Delete Varient
 
-func DeleteVarient(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Content-Type", "application/json")
+func (h *Handler) DeleteVarient(w http.ResponseWriter, r *http.Request) {
 	params := mux.Vars(r)["id"] //get Parameter value as string
-	is_alphanumeric := regexp.MustCompile(`^[a-zA-Z0-9]*$`).MatchString(params)
-	// _id, err := primitive.ObjectIDFromHex(params) // convert params to mongodb Hex ID
-	// if err != nil {
-	// 	fmt.Printf(err.Error())
-	// }
-	if is_alphanumeric {
-		opts := options.Delete().SetCollation(&options.Collation{}) // to specify language-specific rules for string comparison, such as rules for lettercase
-		res, err := varientCollection.DeleteOne(context.TODO(), bson.D{{"vid", params}}, opts)
-		if err != nil {
-			log.Fatal(err)
-			w.WriteHeader(400)
-		} else {
-			fmt.Printf("deleted %v documents\n", res.DeletedCount)
-			json.NewEncoder(w).Encode(res.DeletedCount) // return number of documents deleted
-			w.WriteHeader(200)
-		}
-	} else {
-		json.NewEncoder(w).Encode("Please enter the correct Varient ID!")
-		w.WriteHeader(204)
+	if !validID(w, r, params, "Varient") {
+		return
 	}
+	h.deleteWithPolicy(w, r, "varient", params)
 }
@@ -0,0 +1,88 @@
+package app
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// versionTag formats a document version as a strong entity tag.
+func versionTag(version int64) string {
+	return `"` + strconv.FormatInt(version, 10) + `"`
+}
+
+// versioned is a document sent with its version as the ETag header.
+type versioned interface{ etag() string }
+
+func (p product) etag() string      { return versionTag(p.Version) }
+func (c category) etag() string     { return versionTag(c.Version) }
+func (sc subcategory) etag() string { return versionTag(sc.Version) }
+func (b brand) etag() string        { return versionTag(b.Version) }
+func (v varient) etag() string      { return versionTag(v.Version) }
+
+// writeVersioned sends a document with its ETag.
+func writeVersioned(w http.ResponseWriter, status int, doc versioned) {
+	w.Header().Set("ETag", doc.etag())
+	writeJSON(w, status, doc)
+}
+
+// ifMatch reads the If-Match header as the version a write expects the
+// document to have: anyVersion without the header or with "*". A tag no
+// version can match, such as a weak one, answers 412; a header that is not
+// a single entity tag answers 400. It reports whether to carry on.
+func ifMatch(w http.ResponseWriter, r *http.Request) (int64, bool) {
+	tag := strings.TrimSpace(r.Header.Get("If-Match"))
+	if tag == "" || tag == "*" {
+		return anyVersion, true
+	}
+	weak := strings.HasPrefix(tag, "W/")
+	quoted := strings.TrimPrefix(tag, "W/")
+	if len(quoted) < 2 || quoted[0] != '"' || quoted[len(quoted)-1] != '"' || strings.Contains(quoted[1:len(quoted)-1], `"`) {
+		writeError(w, r, http.StatusBadRequest, codeBadRequest, "If-Match must be a single ETag or *")
+		return 0, false
+	}
+	version, err := strconv.ParseInt(quoted[1:len(quoted)-1], 10, 64)
+	if weak || err != nil || version < 1 {
+		storeError(w, r, ErrVersionMismatch)
+		return 0, false
+	}
+	return version, true
+}
+
+// requireVersion returns ErrNotFound when the document does not exist, and
+// ErrVersionMismatch when it is not at the given version. Writes spanning
+// several documents check it first, so a stale If-Match changes nothing.
+func requireVersion(ctx context.Context, s Stores, ref docRef, version int64) error {
+	var current int64
+	var err error
+	switch ref.Entity {
+	case "product":
+		var v product
+		v, err = s.Products.Get(ctx, ref.ID)
+		current = v.Version
+	case "category":
+		var v category
+		v, err = s.Categories.Get(ctx, ref.ID)
+		current = v.Version
+	case "subcategory":
+		var v subcategory
+		v, err = s.SubCategories.Get(ctx, ref.ID)
+		current = v.Version
+	case "brand":
+		var v brand
+		v, err = s.Brands.Get(ctx, ref.ID)
+		current = v.Version
+	default:
+		var v varient
+		v, err = s.Varients.Get(ctx, ref.ID)
+		current = v.Version
+	}
+	if err != nil {
+		return err
+	}
+	if version != anyVersion && current != version {
+		return ErrVersionMismatch
+	}
+	return nil
+}
@@ -2,56 +2,125 @@ package main
 
 import (
 	"ProductApp/app"
+	"context"
+	"errors"
+	"flag"
 	"log"
 	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
 
 	"github.com/gorilla/mux"
 )
 
 func main() {
 
+	cfg, err := app.LoadConfig(os.Args[1:])
+	if errors.Is(err, flag.ErrHelp) {
+		return
+	} else if err != nil {
+		log.Fatal(err)
+	}
+	if err := app.SetLogLevel(cfg.LogLevel); err != nil {
+		log.Fatal(err)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	a := app.New(cfg)
+	if err := a.Connect(ctx); err != nil {
+		log.Fatal(err)
+	}
+	h := app.NewHandler(a)
+
 	route := mux.NewRouter()
+	route.NotFoundHandler = http.HandlerFunc(app.NotFound)
+	route.MethodNotAllowedHandler = http.HandlerFunc(app.MethodNotAllowed)
 	s := route.PathPrefix("/api").Subrouter() //Base Path
+	s.NotFoundHandler = http.HandlerFunc(app.NotFound)
+	s.MethodNotAllowedHandler = http.HandlerFunc(app.MethodNotAllowed)
 
 	// Product Routes
-	s.HandleFunc("/CreateProduct", app.CreateProduct).Methods("POST")
-	s.HandleFunc("/GetAllProduct", app.GetAllProduct).Methods("GET")
-	s.HandleFunc("/GetProduct/{id}", app.GetProduct).Methods("GET")
-	s.HandleFunc("/UpdateProduct", app.UpdateProduct).Methods("PUT")
-	s.HandleFunc("/UpdateProductStatus", app.UpdateProductStatus).Methods("PUT")
-	s.HandleFunc("/DeleteProduct/{id}", app.DeleteProduct).Methods("DELETE")
+	s.HandleFunc("/CreateProduct", h.CreateProduct).Methods("POST")
+	s.HandleFunc("/GetAllProduct", h.GetAllProduct).Methods("GET")
+	s.HandleFunc("/SearchProduct", h.SearchProduct).Methods("GET")
+	s.HandleFunc("/suggest", h.Suggest).Methods("GET")
+	s.HandleFunc("/GetProduct/{id}", h.GetProduct).Methods("GET")
+	s.HandleFunc("/UpdateProduct", h.UpdateProduct).Methods("PUT")
+	s.HandleFunc("/UpdateProductStatus", h.UpdateProductStatus).Methods("PUT")
+	s.HandleFunc("/DeleteProduct/{id}", h.DeleteProduct).Methods("DELETE")
+
+	// Catalog Routes
+	s.HandleFunc("/catalog/tree", h.CatalogTree).Methods("GET")
+	s.HandleFunc("/catalog/tree/{kind:category|subcategory}/{id}", h.CatalogSubtree).Methods("GET")
+
+	// History Routes
+	s.HandleFunc("/history", h.GetAuditLog).Methods("GET")
+	s.HandleFunc("/history/{kind:product|category|subcategory|brand|varient}/{id}", h.GetHistory).Methods("GET")
+	s.HandleFunc("/revert/{kind:product|category|subcategory|brand|varient}/{id}", h.Revert).Methods("POST")
 
 	// Category Routes
-	s.HandleFunc("/CreateCategory", app.CreateCategory).Methods("POST")
-	s.HandleFunc("/GetAllCategory", app.GetAllCategory).Methods("GET")
-	s.HandleFunc("/GetCategory/{id}", app.GetCategory).Methods("GET")
-	s.HandleFunc("/UpdateCategory", app.UpdateCategory).Methods("PUT")
-	s.HandleFunc("/UpdateCategoryStatus", app.UpdateCategoryStatus).Methods("PUT")
-	s.HandleFunc("/DeleteCategory/{id}", app.DeleteCategory).Methods("DELETE")
+	s.HandleFunc("/CreateCategory", h.CreateCategory).Methods("POST")
+	s.HandleFunc("/GetAllCategory", h.GetAllCategory).Methods("GET")
+	s.HandleFunc("/GetCategory/{id}", h.GetCategory).Methods("GET")
+	s.HandleFunc("/UpdateCategory", h.UpdateCategory).Methods("PUT")
+	s.HandleFunc("/UpdateCategoryStatus", h.UpdateCategoryStatus).Methods("PUT")
+	s.HandleFunc("/MoveCategory", h.MoveCategory).Methods("PUT")
+	s.HandleFunc("/MergeCategory", h.MergeCategory).Methods("PUT")
+	s.HandleFunc("/GetCategoryBreadcrumbs/{id}", h.GetCategoryBreadcrumbs).Methods("GET")
+	s.HandleFunc("/DeleteCategory/{id}", h.DeleteCategory).Methods("DELETE")
 
 	// SubCategory Routes
-	s.HandleFunc("/CreateSubCategory", app.CreateSubCategory).Methods("POST")
-	s.HandleFunc("/GetAllSubCategory", app.GetAllSubCategory).Methods("GET")
-	s.HandleFunc("/GetSubCategory/{id}", app.GetSubCategory).Methods("GET")
-	s.HandleFunc("/UpdateSubCategory", app.UpdateSubCategory).Methods("PUT")
-	s.HandleFunc("/UpdateSubCategoryStatus", app.UpdateSubCategoryStatus).Methods("PUT")
-	s.HandleFunc("/DeleteSubCategory/{id}", app.DeleteSubCategory).Methods("DELETE")
+	s.HandleFunc("/CreateSubCategory", h.CreateSubCategory).Methods("POST")
+	s.HandleFunc("/GetAllSubCategory", h.GetAllSubCategory).Methods("GET")
+	s.HandleFunc("/GetSubCategory/{id}", h.GetSubCategory).Methods("GET")
+	s.HandleFunc("/UpdateSubCategory", h.UpdateSubCategory).Methods("PUT")
+	s.HandleFunc("/UpdateSubCategoryStatus", h.UpdateSubCategoryStatus).Methods("PUT")
+	s.HandleFunc("/MoveSubCategory", h.MoveSubCategory).Methods("PUT")
+	s.HandleFunc("/DeleteSubCategory/{id}", h.DeleteSubCategory).Methods("DELETE")
 
 	// Brand Routes
-	s.HandleFunc("/CreateBrand", app.CreateBrand).Methods("POST")
-	s.HandleFunc("/GetAllBrand", app.GetAllBrand).Methods("GET")
-	s.HandleFunc("/GetBrand/{id}", app.GetBrand).Methods("GET")
-	s.HandleFunc("/UpdateBrand", app.UpdateBrand).Methods("PUT")
-	s.HandleFunc("/UpdateBrandStatus", app.UpdateBrandStatus).Methods("PUT")
-	s.HandleFunc("/DeleteBrand/{id}", app.DeleteBrand).Methods("DELETE")
+	s.HandleFunc("/CreateBrand", h.CreateBrand).Methods("POST")
+	s.HandleFunc("/GetAllBrand", h.GetAllBrand).Methods("GET")
+	s.HandleFunc("/GetBrand/{id}", h.GetBrand).Methods("GET")
+	s.HandleFunc("/UpdateBrand", h.UpdateBrand).Methods("PUT")
+	s.HandleFunc("/UpdateBrandStatus", h.UpdateBrandStatus).Methods("PUT")
+	s.HandleFunc("/DeleteBrand/{id}", h.DeleteBrand).Methods("DELETE")
+	s.HandleFunc("/AttachBrand", h.AttachBrand).Methods("POST")
+	s.HandleFunc("/DetachBrand/{bid}/{scid}", h.DetachBrand).Methods("DELETE")
+	s.HandleFunc("/GetBrandSubCategories/{id}", h.GetBrandSubCategories).Methods("GET")
 
 	// Varient Routes
-	s.HandleFunc("/CreateVarient", app.CreateVarient).Methods("POST")
-	s.HandleFunc("/GetAllVarient", app.GetAllVarient).Methods("GET")
-	s.HandleFunc("/GetVarient/{id}", app.GetVarient).Methods("GET")
-	s.HandleFunc("/UpdateVarient", app.UpdateVarient).Methods("PUT")
-	s.HandleFunc("/UpdateVarientStatus", app.UpdateVarientStatus).Methods("PUT")
-	s.HandleFunc("/DeleteVarient/{id}", app.DeleteVarient).Methods("DELETE")
-
-	log.Fatal(http.ListenAndServe(":8000", s)) // Run Server
+	s.HandleFunc("/CreateVarient", h.CreateVarient).Methods("POST")
+	s.HandleFunc("/GetAllVarient", h.GetAllVarient).Methods("GET")
+	s.HandleFunc("/GetVarient/{id}", h.GetVarient).Methods("GET")
+	s.HandleFunc("/UpdateVarient", h.UpdateVarient).Methods("PUT")
+	s.HandleFunc("/UpdateVarientStatus", h.UpdateVarientStatus).Methods("PUT")
+	s.HandleFunc("/DeleteVarient/{id}", h.DeleteVarient).Methods("DELETE")
+
+	server := &http.Server{
+		Addr:         cfg.Server.Addr,
+		Handler:      app.RequestID(app.Actor(cfg.Auth.ActorHeader, route)),
+		ReadTimeout:  cfg.Server.ReadTimeout,
+		WriteTimeout: cfg.Server.WriteTimeout,
+		IdleTimeout:  cfg.Server.IdleTimeout,
+	}
+	go func() {
+		if err := server.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) { // Run Server
+			log.Fatal(err)
+		}
+	}()
+
+	<-ctx.Done() // wait for Ctrl+C or SIGTERM
+	stop()
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), cfg.Server.ShutdownTimeout)
+	defer cancel()
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		log.Print(err)
+	}
+	if err := a.Close(shutdownCtx); err != nil {
+		log.Print(err)
+	}
 }